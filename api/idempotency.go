@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"order_processing/entity"
+	"order_processing/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	idempotencyKeyHeader       = "Idempotency-Key"
+	idempotentReplayedHeader   = "Idempotent-Replayed"
+	maxIdempotencyKeyLength    = 255
+	idempotencyCleanupInterval = time.Hour
+)
+
+const problemIdempotencyKeyReused = "https://order-processing.local/problems/idempotency-key-reused"
+
+// requestHash identifies the request a key was first used with, hashing the
+// decoded request so formatting of the body doesn't matter
+func requestHash(req *entity.UserOrderRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// replayOrder answers a repeated request with the stored response and the
+// current status of the order, or 422 when the key came with another body
+func replayOrder(ctx *fiber.Ctx, orderRepository repository.OrderRepository, idempotencyKey *entity.IdempotencyKey, hash string) error {
+	if idempotencyKey.RequestHash != hash {
+		return writeProblem(ctx, Problem{
+			Type:   problemIdempotencyKeyReused,
+			Title:  "Idempotency key reused",
+			Status: fiber.StatusUnprocessableEntity,
+			Detail: "the Idempotency-Key was already used with a different request body",
+		})
+	}
+
+	var response fiber.Map
+	if err := json.Unmarshal(idempotencyKey.ResponseBody, &response); err != nil {
+		return internalError(ctx, "unable to create order", err)
+	}
+	userOrderStatus, err := orderRepository.GetUserOrderStatus(ctx.Context(), idempotencyKey.UserOrderID.String())
+	if err != nil {
+		return internalError(ctx, "unable to create order", err)
+	}
+	response["status"] = userOrderStatus.Status
+
+	ctx.Set(idempotentReplayedHeader, "true")
+	return ctx.Status(idempotencyKey.ResponseStatus).JSON(response)
+}
+
+// Expired keys are only skipped by lookups, they are deleted here
+func cleanupIdempotencyKeys(ctx context.Context, idempotencyRepository repository.IdempotencyRepository) {
+	ticker := time.NewTicker(idempotencyCleanupInterval)
+	defer ticker.Stop()
+	for {
+		deleted, err := idempotencyRepository.DeleteExpiredIdempotencyKeys(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("unable to delete expired idempotency keys: %v", err)
+		} else if deleted > 0 {
+			log.Printf("deleted %d expired idempotency keys", deleted)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newIdempotencyKey(key, hash string, userOrderID uuid.UUID, status int, response fiber.Map) (*entity.IdempotencyKey, error) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &entity.IdempotencyKey{
+		Key:            key,
+		RequestHash:    hash,
+		UserOrderID:    userOrderID,
+		ResponseStatus: status,
+		ResponseBody:   body,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(cfg.API.IdempotencyKeyTTL),
+	}, nil
+}
@@ -2,135 +2,236 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"order_processing/client"
+	"order_processing/config"
 	"order_processing/constants"
 	"order_processing/entity"
+	"order_processing/messaging"
+	"order_processing/repository"
 
 	"order_processing/rabbitmq"
 
 	"github.com/google/uuid"
-	amqp "github.com/rabbitmq/amqp091-go"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func main() {
-	conn := rabbitmq.RabbitMQSetup()
-	defer conn.Close()
-	ch := rabbitmq.GetChannel(conn)
-	defer ch.Close()
-
-	// make exchange user order
-	err := ch.ExchangeDeclare(constants.ExchangeUserOrderDirect, "direct", true, false, false, false, nil)
-	rabbitmq.FailOnError(err, "can't create exchange user order")
+var cfg *config.Config
 
-	// make exchange payment
-	err = ch.ExchangeDeclare(constants.ExchangePaymentDirect, "direct", true, false, false, false, nil)
-	rabbitmq.FailOnError(err, "can't create exchange payment")
+func main() {
+	cfg = config.MustLoad()
 
-	// // make exchange stock
-	// err = ch.ExchangeDeclare(constants.ExchangeStockBroadcast, "fanout", true, false, false, false, nil)
-	// rabbitmq.FailOnError(err, "can't create exchange stock")
+	db, err := client.PostgresPool(context.Background(), cfg.Database)
+	rabbitmq.FailOnError(err, "can't connect to database")
+	defer db.Close()
+	orderRepository := repository.NewOrderRepository(db)
+	idempotencyRepository := repository.NewIdempotencyRepository(db)
+	productRepository := repository.NewProductRepository(db)
 
 	app := fiber.New()
-	app.Post("/order", handleOrder(ch))
-
-	app.Listen("localhost:8000")
+	app.Post("/order", handleOrder(orderRepository, idempotencyRepository, productRepository))
+	app.Get("/order/:id", handleGetOrder(orderRepository))
+
+	app.Post("/products", handleCreateProduct(productRepository))
+	app.Get("/products", handleListProducts(productRepository))
+	app.Get("/products/:id", handleGetProduct(productRepository))
+	app.Put("/products/:id", handleUpdateProduct(productRepository))
+	app.Delete("/products/:id", handleArchiveProduct(productRepository))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go cleanupIdempotencyKeys(ctx, idempotencyRepository)
+	go func() {
+		if err := app.Listen(cfg.API.ListenAddress); err != nil {
+			log.Printf("server stopped: %v", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", cfg.ShutdownTimeout)
+	// in-flight handlers finish their transactions, outbox-relay publishes
+	// the messages they stored
+	if err := app.ShutdownWithTimeout(cfg.ShutdownTimeout); err != nil {
+		log.Printf("unable to shut down server: %v", err)
+	}
 }
 
-func handleOrder(ch *amqp.Channel) fiber.Handler {
+func handleOrder(orderRepository repository.OrderRepository, idempotencyRepository repository.IdempotencyRepository, productRepository repository.ProductRepository) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
+		key := ctx.Get(idempotencyKeyHeader)
+		if len(key) > maxIdempotencyKeyLength {
+			return validationFailed(ctx, []InvalidParam{{Name: idempotencyKeyHeader, Reason: fmt.Sprintf("must be at most %d characters", maxIdempotencyKeyLength)}})
+		}
+
 		// get incoming order request
 		var userOrderRequest entity.UserOrderRequest
 		err := ctx.BodyParser(&userOrderRequest)
 		if err != nil {
-			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "invalid json",
-			})
+			return malformedBody(ctx, err)
+		}
+		if invalid := validateUserOrderRequest(&userOrderRequest); len(invalid) > 0 {
+			return validationFailed(ctx, invalid)
 		}
 
-		// publish to rabbitmq
+		// a retried request gets the order created by the first one
+		hash, err := requestHash(&userOrderRequest)
+		if err != nil {
+			return internalError(ctx, "unable to create order", err)
+		}
+		if key != "" {
+			stored, err := idempotencyRepository.GetIdempotencyKey(ctx.Context(), key)
+			if err == nil {
+				return replayOrder(ctx, orderRepository, stored, hash)
+			}
+			if !errors.Is(err, repository.ErrNotFound) {
+				return internalError(ctx, "unable to create order", err)
+			}
+		}
+
+		// only products in the catalog can be ordered, a replayed request was
+		// checked when it was first sent
+		productID := uuid.MustParse(userOrderRequest.ProductID) // validated above
+		product, err := productRepository.GetProduct(ctx.Context(), productID.String())
+		if errors.Is(err, repository.ErrNotFound) {
+			return unknownProduct(ctx, fmt.Sprintf("product %s is not in the catalog", productID))
+		}
+		if err != nil {
+			return internalError(ctx, "unable to create order", err)
+		}
+		if product.IsArchived() {
+			return unknownProduct(ctx, fmt.Sprintf("product %s is archived", productID))
+		}
+		// stock is looked up by the canonical form of the ID
+		userOrderRequest.ProductID = productID.String()
+
 		userOrderID, err := uuid.NewV7()
 		if err != nil {
-			panic(err)
+			return internalError(ctx, "unable to create order", err)
 		}
 
-		// create user order id and passing it to create user order and payment
+		// create user order id and passing it to create user order
 		userOrderRequest.ID = userOrderID
-		body, err := json.Marshal(userOrderRequest)
-		rabbitmq.FailOnError(err, "unable to marshalling data")
+		message, err := messaging.New(messaging.TypeUserOrderRequested, userOrderID.String(), userOrderRequest)
+		if err != nil {
+			return internalError(ctx, "unable to create order", err)
+		}
+		outbox, err := newOutbox(cfg.Exchanges.UserOrderDirect, constants.RoutingKeyUserOrder, message)
+		if err != nil {
+			return internalError(ctx, "unable to create order", err)
+		}
 
-		// create order
-		go CreateOrder(ch, body)
+		userOrder := entity.UserOrder{
+			ID:        userOrderID,
+			UserID:    userOrderRequest.UserID,
+			ProductID: userOrderRequest.ProductID,
+			Quantity:  userOrderRequest.Quantity,
+			Location:  userOrderRequest.Location,
+			CreatedAt: time.Now(),
+			Status:    entity.StatusPending,
+		}
 
-		// add payment
-		go AddPayment(ch, userOrderID.String())
+		// create order is published by outbox-relay, payment starts once
+		// inventory-worker reserved the stock
+		outboxes := []*entity.Outbox{
+			outbox,
+		}
+
+		response := fiber.Map{
+			"message":  "user order created",
+			"order_id": userOrderID,
+			"status":   userOrder.Status,
+		}
+		var idempotencyKey *entity.IdempotencyKey
+		if key != "" {
+			idempotencyKey, err = newIdempotencyKey(key, hash, userOrderID, fiber.StatusAccepted, response)
+			if err != nil {
+				return internalError(ctx, "unable to create order", err)
+			}
+		}
 
-		// // update stock
-		// go UpdateStock(ch, reqCtx, body)
+		err = orderRepository.InsertUserOrderWithOutbox(ctx.Context(), &userOrder, outboxes, idempotencyKey)
+		if errors.Is(err, repository.ErrIdempotencyKeyExists) {
+			// a concurrent request with the same key won
+			stored, err := idempotencyRepository.GetIdempotencyKey(ctx.Context(), key)
+			if err != nil {
+				return internalError(ctx, "unable to create order", err)
+			}
+			return replayOrder(ctx, orderRepository, stored, hash)
+		}
+		if err != nil {
+			return internalError(ctx, "unable to create order", err)
+		}
 
 		// return response back to client
-		return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
-			"message": "user order created",
-		})
+		return ctx.Status(fiber.StatusAccepted).JSON(response)
 	}
 }
 
-func CreateOrder(ch *amqp.Channel, body []byte) {
-	reqCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err := ch.PublishWithContext(reqCtx,
-		constants.ExchangeUserOrderDirect,
-		constants.RoutingKeyUserOrder,
-		false, // mandatory
-		false, // immediate
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         []byte(body),
-			DeliveryMode: amqp.Persistent,
-		})
-	rabbitmq.FailOnError(err, "Failed to publish a message")
-
-	log.Printf("Create Order: [x] Sent %s", body)
+func handleGetOrder(orderRepository repository.OrderRepository) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userOrderID, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid order id",
+			})
+		}
+
+		// get user order along with its payment
+		userOrderStatus, err := orderRepository.GetUserOrderStatus(ctx.Context(), userOrderID.String())
+		if errors.Is(err, repository.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "order not found",
+			})
+		}
+		if err != nil {
+			log.Printf("unable to get user order %s: %v", userOrderID, err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "unable to get order",
+			})
+		}
+
+		// get retry history from dlx
+		userOrderStatus.RetryHistory, err = orderRepository.ListDLXByUserOrderID(ctx.Context(), userOrderID.String())
+		if err != nil {
+			log.Printf("unable to get retry history of user order %s: %v", userOrderID, err)
+			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "unable to get order",
+			})
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(userOrderStatus)
+	}
 }
 
-func AddPayment(ch *amqp.Channel, userOrderID string) {
-	reqCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	body, err := json.Marshal(userOrderID)
+// The outbox row shares its ID with the message, the relay publishes it as
+// the AMQP message ID
+func newOutbox(exchange, routingKey string, message *messaging.Envelope) (*entity.Outbox, error) {
+	payload, err := message.Marshal()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	err = ch.PublishWithContext(reqCtx,
-		constants.ExchangePaymentDirect,
-		constants.RoutingKeyPayment,
-		false, // mandatory
-		false, // immediate
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         []byte(body),
-			DeliveryMode: amqp.Persistent,
-		})
-	rabbitmq.FailOnError(err, "Failed to publish a message")
-
-	log.Printf("Add Payment: [x] Sent %s", body)
+	return &entity.Outbox{
+		ID:         message.MessageID,
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Payload:    payload,
+		CreatedAt:  time.Now(),
+	}, nil
 }
 
-func UpdateStock(ch *amqp.Channel, reqCtx context.Context, body []byte) {
-	err := ch.PublishWithContext(reqCtx,
-		constants.ExchangeStockBroadcast,
-		"",
-		false, // mandatory
-		false, // immediate
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         []byte(body),
-			DeliveryMode: amqp.Persistent,
-		})
-	rabbitmq.FailOnError(err, "Failed to publish a message")
-
-	log.Printf("Update Stock: [x] Sent %s", body)
+func internalError(ctx *fiber.Ctx, message string, err error) error {
+	log.Printf("%s: %v", message, err)
+	return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": message,
+	})
 }
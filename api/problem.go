@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details response
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// InvalidParam is one field failing validation
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+const (
+	problemMalformedBody = "https://order-processing.local/problems/malformed-body"
+	problemValidation    = "https://order-processing.local/problems/validation"
+	problemNotFound      = "https://order-processing.local/problems/not-found"
+	// the ordered product isn't in the catalog or is archived
+	problemUnknownProduct = "https://order-processing.local/problems/unknown-product"
+)
+
+func writeProblem(ctx *fiber.Ctx, problem Problem) error {
+	problem.Instance = ctx.Path()
+	return ctx.Status(problem.Status).JSON(problem, problemContentType)
+}
+
+func malformedBody(ctx *fiber.Ctx, err error) error {
+	return writeProblem(ctx, Problem{
+		Type:   problemMalformedBody,
+		Title:  "Malformed request body",
+		Status: fiber.StatusBadRequest,
+		Detail: err.Error(),
+	})
+}
+
+func validationFailed(ctx *fiber.Ctx, invalidParams []InvalidParam) error {
+	return writeProblem(ctx, Problem{
+		Type:          problemValidation,
+		Title:         "Request validation failed",
+		Status:        fiber.StatusBadRequest,
+		Detail:        "one or more fields are invalid",
+		InvalidParams: invalidParams,
+	})
+}
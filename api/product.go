@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"order_processing/entity"
+	"order_processing/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultProductPageSize = 20
+	maxProductPageSize     = 100
+)
+
+func handleCreateProduct(productRepository repository.ProductRepository) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		var productRequest entity.ProductRequest
+		if err := ctx.BodyParser(&productRequest); err != nil {
+			return malformedBody(ctx, err)
+		}
+		if invalid := validateProductRequest(&productRequest); len(invalid) > 0 {
+			return validationFailed(ctx, invalid)
+		}
+
+		productID, err := uuid.NewV7()
+		if err != nil {
+			return internalError(ctx, "unable to create product", err)
+		}
+		now := time.Now()
+		product := newProduct(productID, &productRequest, now)
+		product.CreatedAt = now
+
+		if err := productRepository.CreateProduct(ctx.Context(), product); err != nil {
+			return internalError(ctx, "unable to create product", err)
+		}
+		return ctx.Status(fiber.StatusCreated).JSON(product)
+	}
+}
+
+// Products are paged by ID: ?limit=20&cursor={next_cursor of the previous
+// page}, archived ones only with ?include_archived=true
+func handleListProducts(productRepository repository.ProductRepository) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		filter := entity.ProductFilter{
+			Limit:           defaultProductPageSize,
+			IncludeArchived: ctx.QueryBool("include_archived"),
+		}
+		var invalid []InvalidParam
+		if raw := ctx.Query("limit"); raw != "" {
+			limit, err := strconv.Atoi(raw)
+			if err != nil || limit < 1 || limit > maxProductPageSize {
+				invalid = append(invalid, InvalidParam{Name: "limit", Reason: "must be between 1 and " + strconv.Itoa(maxProductPageSize)})
+			}
+			filter.Limit = limit
+		}
+		if raw := ctx.Query("cursor"); raw != "" {
+			cursor, err := uuid.Parse(raw)
+			if err != nil {
+				invalid = append(invalid, InvalidParam{Name: "cursor", Reason: "must be a product ID"})
+			}
+			filter.After = &cursor
+		}
+		if len(invalid) > 0 {
+			return validationFailed(ctx, invalid)
+		}
+
+		// one more than the page tells whether there is a next page
+		pageSize := filter.Limit
+		filter.Limit++
+		products, err := productRepository.ListProducts(ctx.Context(), filter)
+		if err != nil {
+			return internalError(ctx, "unable to list products", err)
+		}
+
+		response := fiber.Map{
+			"products":    products,
+			"next_cursor": nil,
+		}
+		if len(products) > pageSize {
+			products = products[:pageSize]
+			response["products"] = products
+			response["next_cursor"] = products[pageSize-1].ID
+		}
+		return ctx.Status(fiber.StatusOK).JSON(response)
+	}
+}
+
+func handleGetProduct(productRepository repository.ProductRepository) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		productID, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return productNotFound(ctx)
+		}
+
+		product, err := productRepository.GetProduct(ctx.Context(), productID.String())
+		if errors.Is(err, repository.ErrNotFound) {
+			return productNotFound(ctx)
+		}
+		if err != nil {
+			return internalError(ctx, "unable to get product", err)
+		}
+		return ctx.Status(fiber.StatusOK).JSON(product)
+	}
+}
+
+func handleUpdateProduct(productRepository repository.ProductRepository) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		productID, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return productNotFound(ctx)
+		}
+
+		var productRequest entity.ProductRequest
+		if err := ctx.BodyParser(&productRequest); err != nil {
+			return malformedBody(ctx, err)
+		}
+		if invalid := validateProductRequest(&productRequest); len(invalid) > 0 {
+			return validationFailed(ctx, invalid)
+		}
+
+		product := newProduct(productID, &productRequest, time.Now())
+		err = productRepository.UpdateProduct(ctx.Context(), product)
+		if errors.Is(err, repository.ErrNotFound) {
+			return productNotFound(ctx)
+		}
+		var belowReserved *repository.StockBelowReservedError
+		if errors.As(err, &belowReserved) {
+			return validationFailed(ctx, []InvalidParam{{Name: "stock." + belowReserved.Location, Reason: fmt.Sprintf("can't be below the %d reserved", belowReserved.Reserved)}})
+		}
+		if err != nil {
+			return internalError(ctx, "unable to update product", err)
+		}
+
+		// answer with the stock of every location, not only the updated ones
+		updated, err := productRepository.GetProduct(ctx.Context(), productID.String())
+		if err != nil {
+			return internalError(ctx, "unable to update product", err)
+		}
+		return ctx.Status(fiber.StatusOK).JSON(updated)
+	}
+}
+
+// Archived products are kept for the orders referencing them, they can't be
+// ordered anymore
+func handleArchiveProduct(productRepository repository.ProductRepository) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		productID, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return productNotFound(ctx)
+		}
+
+		err = productRepository.ArchiveProduct(ctx.Context(), productID.String())
+		if errors.Is(err, repository.ErrNotFound) {
+			return productNotFound(ctx)
+		}
+		if err != nil {
+			return internalError(ctx, "unable to archive product", err)
+		}
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// newProduct builds the product of a request, its stock sorted by location
+func newProduct(id uuid.UUID, req *entity.ProductRequest, now time.Time) *entity.Product {
+	product := &entity.Product{
+		ID:          id,
+		Name:        req.Name,
+		Description: req.Description,
+		Price:       req.Price,
+		Stock:       []entity.Stock{},
+		UpdatedAt:   now,
+	}
+	for location, available := range req.Stock {
+		product.Stock = append(product.Stock, entity.Stock{
+			ProductID: id.String(),
+			Location:  location,
+			Available: available,
+			UpdatedAt: now,
+		})
+	}
+	sort.Slice(product.Stock, func(i, j int) bool {
+		return product.Stock[i].Location < product.Stock[j].Location
+	})
+	return product
+}
+
+// unknownProduct answers an order for a product that can't be ordered
+func unknownProduct(ctx *fiber.Ctx, detail string) error {
+	return writeProblem(ctx, Problem{
+		Type:   problemUnknownProduct,
+		Title:  "Product can't be ordered",
+		Status: fiber.StatusUnprocessableEntity,
+		Detail: detail,
+	})
+}
+
+func productNotFound(ctx *fiber.Ctx) error {
+	return writeProblem(ctx, Problem{
+		Type:   problemNotFound,
+		Title:  "Product not found",
+		Status: fiber.StatusNotFound,
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"order_processing/entity"
+	"order_processing/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// stubProducts serves the catalog from a map, only lookups are needed
+type stubProducts struct {
+	repository.ProductRepository
+	products map[string]*entity.Product
+}
+
+func (sp *stubProducts) GetProduct(ctx context.Context, id string) (*entity.Product, error) {
+	product, ok := sp.products[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return product, nil
+}
+
+func TestOrderUnknownProduct(t *testing.T) {
+	archivedAt := time.Now()
+	archived := &entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "retired", ArchivedAt: &archivedAt}
+	products := &stubProducts{products: map[string]*entity.Product{archived.ID.String(): archived}}
+
+	app := fiber.New()
+	app.Post("/order", handleOrder(nil, nil, products))
+
+	tests := []struct {
+		name      string
+		productID uuid.UUID
+	}{
+		{name: "not in the catalog", productID: uuid.Must(uuid.NewV7())},
+		{name: "archived", productID: archived.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"user_id":"u1","product_id":"` + tt.productID.String() + `","quantity":1,"location":"jakarta"}`
+			req := httptest.NewRequest(fiber.MethodPost, "/order", strings.NewReader(body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != fiber.StatusUnprocessableEntity {
+				t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusUnprocessableEntity)
+			}
+			if got := resp.Header.Get(fiber.HeaderContentType); got != problemContentType {
+				t.Errorf("content type = %q, want %q", got, problemContentType)
+			}
+			var problem Problem
+			if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+				t.Fatalf("decode problem: %v", err)
+			}
+			if problem.Type != problemUnknownProduct {
+				t.Errorf("type = %q, want %q", problem.Type, problemUnknownProduct)
+			}
+			if problem.Status != fiber.StatusUnprocessableEntity {
+				t.Errorf("problem status = %d, want %d", problem.Status, fiber.StatusUnprocessableEntity)
+			}
+			if !strings.Contains(problem.Detail, tt.productID.String()) {
+				t.Errorf("detail %q doesn't name the product", problem.Detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxOrderQuantity  = 100
+	maxUserIDLength   = 64
+	maxLocationLength = 255
+)
+
+// validateUserOrderRequest checks every field and reports all that are
+// invalid, named by their JSON keys
+func validateUserOrderRequest(req *entity.UserOrderRequest) []InvalidParam {
+	var invalid []InvalidParam
+	fail := func(name, reason string) {
+		invalid = append(invalid, InvalidParam{Name: name, Reason: reason})
+	}
+
+	switch {
+	case strings.TrimSpace(req.UserID) == "":
+		fail("user_id", "is required")
+	case utf8.RuneCountInString(req.UserID) > maxUserIDLength:
+		fail("user_id", fmt.Sprintf("must be at most %d characters", maxUserIDLength))
+	}
+
+	if strings.TrimSpace(req.ProductID) == "" {
+		fail("product_id", "is required")
+	} else if _, err := uuid.Parse(req.ProductID); err != nil {
+		fail("product_id", "must be a UUID")
+	}
+
+	if req.Quantity < 1 || req.Quantity > maxOrderQuantity {
+		fail("quantity", fmt.Sprintf("must be between 1 and %d", maxOrderQuantity))
+	}
+
+	switch {
+	case strings.TrimSpace(req.Location) == "":
+		fail("location", "is required")
+	case utf8.RuneCountInString(req.Location) > maxLocationLength:
+		fail("location", fmt.Sprintf("must be at most %d characters", maxLocationLength))
+	}
+
+	return invalid
+}
+
+const (
+	maxProductNameLength        = 255
+	maxProductDescriptionLength = 2000
+)
+
+// validateProductRequest checks every field and reports all that are
+// invalid, named by their JSON keys
+func validateProductRequest(req *entity.ProductRequest) []InvalidParam {
+	var invalid []InvalidParam
+	fail := func(name, reason string) {
+		invalid = append(invalid, InvalidParam{Name: name, Reason: reason})
+	}
+
+	switch {
+	case strings.TrimSpace(req.Name) == "":
+		fail("name", "is required")
+	case utf8.RuneCountInString(req.Name) > maxProductNameLength:
+		fail("name", fmt.Sprintf("must be at most %d characters", maxProductNameLength))
+	}
+
+	if utf8.RuneCountInString(req.Description) > maxProductDescriptionLength {
+		fail("description", fmt.Sprintf("must be at most %d characters", maxProductDescriptionLength))
+	}
+
+	if req.Price < 0 {
+		fail("price", "can't be negative")
+	}
+
+	for location, available := range req.Stock {
+		switch {
+		case strings.TrimSpace(location) == "":
+			fail("stock", "locations can't be empty")
+		case utf8.RuneCountInString(location) > maxLocationLength:
+			fail("stock."+location, fmt.Sprintf("location must be at most %d characters", maxLocationLength))
+		case available < 0:
+			fail("stock."+location, "can't be negative")
+		}
+	}
+
+	return invalid
+}
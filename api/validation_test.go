@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// invalid requests are answered before any repository is used, so none are
+// needed
+func newTestApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/order", handleOrder(nil, nil, nil))
+	app.Post("/products", handleCreateProduct(nil))
+	return app
+}
+
+func TestInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		header      map[string]string
+		body        string
+		wantType    string
+		wantInvalid []InvalidParam
+	}{
+		{
+			name:     "malformed order",
+			path:     "/order",
+			body:     `{"user_id":`,
+			wantType: problemMalformedBody,
+		},
+		{
+			name:     "empty order",
+			path:     "/order",
+			body:     `{}`,
+			wantType: problemValidation,
+			wantInvalid: []InvalidParam{
+				{Name: "user_id", Reason: "is required"},
+				{Name: "product_id", Reason: "is required"},
+				{Name: "quantity", Reason: "must be between 1 and 100"},
+				{Name: "location", Reason: "is required"},
+			},
+		},
+		{
+			name:     "order out of bounds",
+			path:     "/order",
+			body:     `{"user_id":"` + strings.Repeat("u", maxUserIDLength+1) + `","product_id":"not-a-uuid","quantity":101,"location":"   "}`,
+			wantType: problemValidation,
+			wantInvalid: []InvalidParam{
+				{Name: "user_id", Reason: "must be at most 64 characters"},
+				{Name: "product_id", Reason: "must be a UUID"},
+				{Name: "quantity", Reason: "must be between 1 and 100"},
+				{Name: "location", Reason: "is required"},
+			},
+		},
+		{
+			name:     "idempotency key too long",
+			path:     "/order",
+			header:   map[string]string{idempotencyKeyHeader: strings.Repeat("k", maxIdempotencyKeyLength+1)},
+			body:     `{}`,
+			wantType: problemValidation,
+			wantInvalid: []InvalidParam{
+				{Name: idempotencyKeyHeader, Reason: "must be at most 255 characters"},
+			},
+		},
+		{
+			name:     "malformed product",
+			path:     "/products",
+			body:     `[]`,
+			wantType: problemMalformedBody,
+		},
+		{
+			name:     "invalid product",
+			path:     "/products",
+			body:     `{"name":" ","price":-1,"stock":{"warehouse":-5}}`,
+			wantType: problemValidation,
+			wantInvalid: []InvalidParam{
+				{Name: "name", Reason: "is required"},
+				{Name: "price", Reason: "can't be negative"},
+				{Name: "stock.warehouse", Reason: "can't be negative"},
+			},
+		},
+	}
+
+	app := newTestApp()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+			}
+			if got := resp.Header.Get(fiber.HeaderContentType); got != problemContentType {
+				t.Errorf("content type = %q, want %q", got, problemContentType)
+			}
+
+			var problem Problem
+			if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+				t.Fatalf("decode problem: %v", err)
+			}
+			if problem.Type != tt.wantType {
+				t.Errorf("type = %q, want %q", problem.Type, tt.wantType)
+			}
+			if problem.Status != fiber.StatusBadRequest {
+				t.Errorf("problem status = %d, want %d", problem.Status, fiber.StatusBadRequest)
+			}
+			if problem.Title == "" {
+				t.Error("problem has no title")
+			}
+			if problem.Instance != tt.path {
+				t.Errorf("instance = %q, want %q", problem.Instance, tt.path)
+			}
+			if len(problem.InvalidParams) != len(tt.wantInvalid) {
+				t.Fatalf("invalid params = %v, want %v", problem.InvalidParams, tt.wantInvalid)
+			}
+			for i, want := range tt.wantInvalid {
+				if problem.InvalidParams[i] != want {
+					t.Errorf("invalid param %d = %v, want %v", i, problem.InvalidParams[i], want)
+				}
+			}
+		})
+	}
+}
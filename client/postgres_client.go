@@ -3,25 +3,53 @@ package client
 import (
 	"context"
 	"fmt"
-	"os"
+	"log"
+	"time"
 
-	"github.com/jackc/pgx/v5"
+	"order_processing/config"
+
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func PostgresClient(user, password, host, port, dbName string) *pgx.Conn {
-	dbPath := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s",
-		user,
-		password,
-		host,
-		port,
-		dbName,
-	)
+// PostgresPool creates the connection pool shared by the whole process. The
+// first connection is retried with backoff so a database that is still
+// starting up doesn't kill the process.
+func PostgresPool(ctx context.Context, dbConfig config.DatabaseConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(dbConfig.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse database url: %w", err)
+	}
+	poolConfig.MaxConns = dbConfig.MaxConns
+	poolConfig.MinConns = dbConfig.MinConns
+	poolConfig.MaxConnLifetime = dbConfig.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = dbConfig.MaxConnIdleTime
+	poolConfig.ConnConfig.ConnectTimeout = dbConfig.ConnectTimeout
 
-	conn, err := pgx.Connect(context.Background(), dbPath)
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("create database pool: %w", err)
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, dbConfig.ConnectTimeout)
+		err = pool.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			return pool, nil
+		}
+		if attempt >= dbConfig.ConnectRetries {
+			pool.Close()
+			return nil, fmt.Errorf("unable to connect to database after %d attempts: %w", attempt+1, err)
+		}
+
+		log.Printf("Unable to connect to database, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			pool.Close()
+			return nil, ctx.Err()
+		}
+		backoff = min(backoff*2, 10*time.Second)
 	}
-	return conn
 }
@@ -0,0 +1,301 @@
+// Command dlx-replay lists messages given up in the dlx table and republishes
+// them with a fresh retry budget. Payments go back to the payment exchange,
+// the saga of the order was compensated when the payment was given up, so
+// its stock is reserved again and the saga reopened first. Records of the
+// other workers go back to the queue they were consumed from.
+//
+//	dlx-replay -since 2024-01-01T00:00:00Z -error timeout -dry-run
+//	dlx-replay -service notification -id 0190f1c2-... -force
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"order_processing/client"
+	"order_processing/config"
+	"order_processing/constants"
+	"order_processing/entity"
+	"order_processing/messaging"
+	"order_processing/rabbitmq"
+	"order_processing/repository"
+	"order_processing/topology"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const paymentService = "payment"
+
+type options struct {
+	filter entity.DLXFilter
+	dryRun bool
+	force  bool
+	rate   float64
+}
+
+func main() {
+	opts, err := parseFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg := config.MustLoad()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := client.PostgresPool(ctx, cfg.Database)
+	rabbitmq.FailOnError(err, "can't connect to database")
+	defer db.Close()
+	orderRepository := repository.NewOrderRepository(db)
+	inventoryRepository := repository.NewInventoryRepository(db)
+	sagaRepository := repository.NewSagaRepository(db)
+
+	records, err := orderRepository.ListDLX(ctx, opts.filter)
+	rabbitmq.FailOnError(err, "can't list dlx records")
+	if len(records) == 0 {
+		log.Println("No dlx records to replay")
+		return
+	}
+
+	if opts.dryRun {
+		for _, dlx := range records {
+			printRecord(dlx)
+		}
+		log.Printf("Dry run, %d records would be replayed", len(records))
+		return
+	}
+
+	conn, err := rabbitmq.Dial(ctx, cfg.RabbitMQ.URL, topology.New(cfg).Apply)
+	rabbitmq.FailOnError(err, "Failed to connect to RabbitMQ")
+	defer conn.Close()
+	publisher := conn.NewPublisher(5 * time.Second)
+	defer publisher.Close()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.rate))
+	defer ticker.Stop()
+
+	replayed, failed := 0, 0
+	for i, dlx := range records {
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			log.Println("Interrupted")
+			break
+		}
+
+		if err := replay(ctx, cfg, publisher, orderRepository, inventoryRepository, sagaRepository, dlx, opts.force); err != nil {
+			log.Printf("❌ %s: %v", dlx.ID, err)
+			failed++
+			continue
+		}
+		log.Printf("🔁 %s replayed", dlx.ID)
+		replayed++
+	}
+
+	log.Printf("Replayed %d of %d records, %d failed", replayed, len(records), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseFlags() (options, error) {
+	var opts options
+	var since, until string
+	flag.StringVar(&opts.filter.ID, "id", "", "replay a single dlx record")
+	flag.StringVar(&opts.filter.ServiceName, "service", paymentService, "only records of this service, empty for every service")
+	flag.StringVar(&opts.filter.ErrorContains, "error", "", "only records whose error contains this text")
+	flag.StringVar(&since, "since", "", "only records created at or after this RFC 3339 time")
+	flag.StringVar(&until, "until", "", "only records created before this RFC 3339 time")
+	flag.IntVar(&opts.filter.Limit, "limit", 100, "replay at most this many records")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "list the records without replaying them")
+	flag.BoolVar(&opts.force, "force", false, "replay records that were already replayed")
+	flag.Float64Var(&opts.rate, "rate", 5, "records replayed per second")
+	flag.Parse()
+
+	var err error
+	if since != "" {
+		if opts.filter.CreatedFrom, err = time.Parse(time.RFC3339, since); err != nil {
+			return opts, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if until != "" {
+		if opts.filter.CreatedTo, err = time.Parse(time.RFC3339, until); err != nil {
+			return opts, fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+	if opts.rate <= 0 {
+		return opts, errors.New("-rate must be positive")
+	}
+	opts.filter.IncludeReplayed = opts.force
+	return opts, nil
+}
+
+// The order is reopened before the event is published, otherwise
+// payment-worker could see the cancelled order and skip the payment
+func replay(ctx context.Context, cfg *config.Config, publisher *rabbitmq.ReconnectingPublisher, orderRepository repository.OrderRepository, inventoryRepository repository.InventoryRepository, sagaRepository repository.SagaRepository, dlx entity.DLX, force bool) error {
+	if dlx.ServiceName != paymentService {
+		return replayToSource(ctx, publisher, orderRepository, dlx, force)
+	}
+
+	body, headers, err := message(ctx, orderRepository, dlx)
+	if err != nil {
+		return err
+	}
+	headers[rabbitmq.HeaderReplayedFrom] = dlx.ID.String()
+
+	if dlx.UserOrderID != nil {
+		if err := reopenSaga(ctx, cfg, inventoryRepository, sagaRepository, *dlx.UserOrderID); err != nil {
+			return fmt.Errorf("reopen saga: %w", err)
+		}
+	}
+
+	if err := orderRepository.ReplayDLX(ctx, dlx.ID.String(), force); err != nil {
+		return fmt.Errorf("mark replayed: %w", err)
+	}
+
+	err = publisher.Publish(ctx,
+		cfg.Exchanges.PaymentDirect,
+		constants.RoutingKeyPayment,
+		amqp.Publishing{
+			Headers:      headers,
+			ContentType:  "application/json",
+			Type:         messaging.TypeStockReserved,
+			Body:         body,
+			DeliveryMode: amqp.Persistent,
+		})
+	if err != nil {
+		return fmt.Errorf("marked replayed but not published, run again with -id %s -force: %w", dlx.ID, err)
+	}
+	return nil
+}
+
+// replayToSource publishes the stored message to the queue it was consumed
+// from through the default exchange, so exchange bindings don't copy it to
+// other queues
+func replayToSource(ctx context.Context, publisher *rabbitmq.ReconnectingPublisher, orderRepository repository.OrderRepository, dlx entity.DLX, force bool) error {
+	if len(dlx.Payload) == 0 || dlx.SourceQueue == "" {
+		return errors.New("record has no stored message to replay")
+	}
+	var envelope messaging.Envelope
+	if err := json.Unmarshal(dlx.Payload, &envelope); err != nil {
+		return fmt.Errorf("stored message: %w", err)
+	}
+	publishing, err := envelope.Publishing()
+	if err != nil {
+		return fmt.Errorf("stored message: %w", err)
+	}
+	publishing.Headers = replayHeaders(dlx.Headers)
+	publishing.Headers[rabbitmq.HeaderReplayedFrom] = dlx.ID.String()
+
+	if err := orderRepository.ReplayDLX(ctx, dlx.ID.String(), force); err != nil {
+		return fmt.Errorf("mark replayed: %w", err)
+	}
+
+	if err := publisher.Publish(ctx, "", dlx.SourceQueue, publishing); err != nil {
+		return fmt.Errorf("marked replayed but not published, run again with -id %s -force: %w", dlx.ID, err)
+	}
+	return nil
+}
+
+// reopenSaga reserves the released stock of the order again and moves its
+// saga back to awaiting the payment. Orders from before stock was reserved,
+// or before sagas were tracked, have nothing to reopen.
+func reopenSaga(ctx context.Context, cfg *config.Config, inventoryRepository repository.InventoryRepository, sagaRepository repository.SagaRepository, userOrderID string) error {
+	saga, err := sagaRepository.GetSaga(ctx, userOrderID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	// the compensation could release the stock reserved below once more
+	if saga != nil && saga.State == entity.SagaCompensating {
+		return errors.New("saga is still compensating, replay once it is cancelled")
+	}
+
+	reservation, available, err := inventoryRepository.ReacquireStock(ctx, userOrderID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	if err == nil && reservation.Status != entity.ReservationReserved {
+		return fmt.Errorf("%d of %s needed at %s, %d available", reservation.Quantity, reservation.ProductID, reservation.Location, available)
+	}
+
+	if saga == nil || saga.State != entity.SagaCancelled {
+		return nil
+	}
+	saga.State = entity.SagaAwaitingPayment
+	saga.StockReserved = reservation != nil
+	saga.Error = ""
+	saga.Deadline = time.Now().Add(cfg.Saga.Timeout)
+	// released again by the sweeper once the deadline passes if the replay stops here
+	return sagaRepository.UpdateSaga(ctx, saga)
+}
+
+// message is the stored message without its retry headers, so the payment
+// starts over with every retry available. Records stored before the message
+// was kept get the event rebuilt from their user order.
+func message(ctx context.Context, orderRepository repository.OrderRepository, dlx entity.DLX) ([]byte, amqp.Table, error) {
+	// records from before payment consumed stock.reserved carry an
+	// order.created body, they are rebuilt from the order like the oldest ones
+	if len(dlx.Payload) > 0 {
+		var stockReserved entity.StockReservedEvent
+		if _, err := messaging.Decode(dlx.Payload, messaging.TypeStockReserved, &stockReserved); err == nil {
+			return dlx.Payload, replayHeaders(dlx.Headers), nil
+		}
+	}
+
+	if dlx.UserOrderID == nil {
+		return nil, nil, errors.New("record has neither a stock.reserved payload nor a user order")
+	}
+	userOrder, err := orderRepository.GetUserOrderStatus(ctx, *dlx.UserOrderID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get user order %s: %w", *dlx.UserOrderID, err)
+	}
+	// the stock of the order is still reserved, payment picks up from there
+	message, err := messaging.New(messaging.TypeStockReserved, userOrder.ID.String(), entity.StockReservedEvent{
+		UserOrderID: userOrder.ID,
+		UserID:      userOrder.UserID,
+		ProductID:   userOrder.ProductID,
+		Quantity:    userOrder.Quantity,
+		Location:    userOrder.Location,
+		ReservedAt:  time.Now(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := message.Marshal()
+	return body, amqp.Table{}, err
+}
+
+// Headers come back from JSONB, only values AMQP can carry as they are are
+// kept
+func replayHeaders(stored map[string]any) amqp.Table {
+	headers := rabbitmq.ResetRetryState(stored)
+	for k, v := range headers {
+		switch v.(type) {
+		case string, bool, float64:
+		default:
+			delete(headers, k)
+		}
+	}
+	return headers
+}
+
+func printRecord(dlx entity.DLX) {
+	userOrderID := "-"
+	if dlx.UserOrderID != nil {
+		userOrderID = *dlx.UserOrderID
+	}
+	fmt.Printf("%s\t%s\torder %s\tretries %d\t%s\t%s\n", dlx.ID, dlx.ServiceName, userOrderID, dlx.NumberOfRetries, dlx.CreatedAt.Format(time.RFC3339), dlx.Error)
+}
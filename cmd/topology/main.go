@@ -0,0 +1,73 @@
+// Command topology applies the RabbitMQ topology or verifies it against the
+// live broker.
+//
+//	topology apply
+//	topology verify
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"order_processing/config"
+	"order_processing/topology"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: topology apply|verify")
+		os.Exit(2)
+	}
+	// only talks to RabbitMQ, DATABASE_URL isn't needed
+	cfg := config.MustLoadWithoutDatabase()
+	definition := topology.New(cfg)
+
+	switch os.Args[1] {
+	case "apply":
+		if err := apply(cfg, definition); err != nil {
+			log.Fatalf("apply topology: %v", err)
+		}
+		log.Println("✅ Topology applied")
+
+	case "verify":
+		uri, err := amqp.ParseURI(cfg.RabbitMQ.URL)
+		if err != nil {
+			log.Fatalf("parse amqp url: %v", err)
+		}
+		diffs, err := definition.Verify(context.Background(), cfg.RabbitMQ.ManagementURL, uri.Vhost)
+		if err != nil {
+			log.Fatalf("verify topology: %v", err)
+		}
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+		if len(diffs) > 0 {
+			os.Exit(1)
+		}
+		log.Println("✅ Broker matches the topology")
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, usage: topology apply|verify\n", os.Args[1])
+		os.Exit(2)
+	}
+}
+
+func apply(cfg *config.Config, definition topology.Topology) error {
+	conn, err := amqp.Dial(cfg.RabbitMQ.URL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return definition.Apply(ch)
+}
@@ -1,32 +1,48 @@
+// Package constants holds the default exchange, routing key and queue names,
+// exchange and queue names can be overridden through the config package.
 package constants
 
 const (
-	// exhange
+	// exchange
 	ExchangeUserOrderDirect = "exchange_user_order_direct"
-	ExchangePaymentDirect   = "exhange_payment_direct"
+	ExchangePaymentDirect   = "exchange_payment_direct"
 	ExchangeDLX             = "exchange_dlx"
 	ExchangeStockBroadcast  = "exchange_stock_broadcast"
+	ExchangeOrderEvents     = "exchange_order_events"
 
 	// routing key
 	RoutingKeyUserOrder = "routing_key_user_order"
 	RoutingKeyPayment   = "routing_key_payment"
 	RoutingKeyRetry     = "routing_key_retry"
 
-	// queue
-	UserOrderQueue    = "user_order_queue"
-	PaymentQueue      = "payment_queue"
-	RetryQueue        = "retry_queue"
-	InventoryQueue    = "inventory_queue"
-	NotificationQueue = "notification_queue"
+	RoutingKeyUserOrderRetry    = "routing_key_user_order_retry"
+	RoutingKeyInventoryRetry    = "routing_key_inventory_retry"
+	RoutingKeyNotificationRetry = "routing_key_notification_retry"
+	RoutingKeySagaRetry         = "routing_key_saga_retry"
+
+	RoutingKeyPaymentRefund      = "routing_key_payment_refund"
+	RoutingKeyPaymentRefundRetry = "routing_key_payment_refund_retry"
 
-	// database postgres connection
-	Username = "root"
-	Password = "secret"
-	Host     = "serverhost"
-	Port     = "5432"
-	DBName   = "order_processing_db"
+	// event routing key
+	RoutingKeyOrderCreated        = "order.created"
+	RoutingKeyStockReserved       = "stock.reserved"
+	RoutingKeyStockInsufficient   = "stock.insufficient"
+	RoutingKeyPaymentSucceeded    = "payment.succeeded"
+	RoutingKeyPaymentFailed       = "payment.failed"
+	RoutingKeyPaymentDeadLettered = "payment.dead_lettered"
 
-	// other
-	MaxRetries        = 3
-	RetryDelaySeconds = 5
+	// queue
+	UserOrderQueue          = "user_order_queue"
+	PaymentQueue            = "payment_queue"
+	RetryQueue              = "retry_queue"
+	UserOrderRetryQueue     = "user_order_retry_queue"
+	ParkingLotQueue         = "parking_lot_queue"
+	InventoryQueue          = "inventory_queue"
+	InventoryRetryQueue     = "inventory_retry_queue"
+	NotificationQueue       = "notification_queue"
+	NotificationRetryQueue  = "notification_retry_queue"
+	SagaQueue               = "saga_queue"
+	SagaRetryQueue          = "saga_retry_queue"
+	PaymentRefundQueue      = "payment_refund_queue"
+	PaymentRefundRetryQueue = "payment_refund_retry_queue"
 )
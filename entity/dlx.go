@@ -2,17 +2,45 @@
 package entity
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type DLX struct {
-	ID              uuid.UUID `json:"id"`
-	PaymentID       string    `json:"payment_id"` // Foreign key (no constraint for now)
-	NumberOfRetries int       `json:"number_of_retries"`
-	IsReplayed      bool      `json:"is_replayed"`
-	ServiceName     string    `json:"service_name"`
-	Error           string    `json:"error"`
-	CreatedAt       time.Time `json:"created_at"`
+	ID              uuid.UUID  `json:"id"`
+	PaymentID       *string    `json:"payment_id"`    // Foreign key (no constraint for now), nil before a payment exists
+	UserOrderID     *string    `json:"user_order_id"` // nil on records stored before it was tracked
+	NumberOfRetries int        `json:"number_of_retries"`
+	IsReplayed      bool       `json:"is_replayed"`
+	ReplayedAt      *time.Time `json:"replayed_at"`
+	ServiceName     string     `json:"service_name"`
+	Error           string     `json:"error"`
+	CreatedAt       time.Time  `json:"created_at"`
+
+	// the dead lettered message as it was consumed, nil on older records
+	Payload     json.RawMessage `json:"payload"`
+	Headers     map[string]any  `json:"headers"`
+	RoutingKey  string          `json:"routing_key"`
+	SourceQueue string          `json:"source_queue"`
+	History     []DLXAttempt    `json:"history"`
+}
+
+// One failed attempt of a message before it was given up
+type DLXAttempt struct {
+	Attempt  int       `json:"attempt"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Narrows down DLX records, zero fields match everything
+type DLXFilter struct {
+	ID              string
+	ServiceName     string
+	ErrorContains   string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	IncludeReplayed bool
+	Limit           int
 }
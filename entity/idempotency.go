@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Remembers the response to a request sent with an Idempotency-Key header
+type IdempotencyKey struct {
+	Key            string          `json:"key"`
+	RequestHash    string          `json:"request_hash"`
+	UserOrderID    uuid.UUID       `json:"user_order_id"`
+	ResponseStatus int             `json:"response_status"`
+	ResponseBody   json.RawMessage `json:"response_body"`
+	CreatedAt      time.Time       `json:"created_at"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notification rendered for one event, EventID is derived from the event so
+// a redelivered or republished event isn't sent twice
+type Notification struct {
+	EventID     uuid.UUID `json:"event_id"`
+	Type        string    `json:"type"`
+	UserID      string    `json:"user_id"`
+	UserOrderID string    `json:"user_order_id"`
+	Subject     string    `json:"subject"`
+	Body        string    `json:"body"`
+	SentAt      time.Time `json:"sent_at"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Outbox struct {
+	ID         uuid.UUID  `json:"id"`
+	Exchange   string     `json:"exchange"`
+	RoutingKey string     `json:"routing_key"`
+	Payload    []byte     `json:"payload"`
+	Attempts   int        `json:"attempts"`
+	LastError  *string    `json:"last_error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	SentAt     *time.Time `json:"sent_at"`
+	DeadAt     *time.Time `json:"dead_at"`
+}
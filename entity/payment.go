@@ -11,3 +11,62 @@ type Payment struct {
 	UserOrderID string
 	CreatedAt   time.Time `json:"created_at"`
 }
+
+// Emitted by payment-worker once the order is paid
+type PaymentSucceededEvent struct {
+	UserOrderID uuid.UUID `json:"user_order_id"`
+	PaymentID   uuid.UUID `json:"payment_id"`
+	UserID      string    `json:"user_id"`
+	ProductID   string    `json:"product_id"`
+	Quantity    int       `json:"quantity"`
+	PaidAt      time.Time `json:"paid_at"`
+}
+
+// Emitted by payment-worker for every failed attempt that is retried
+type PaymentFailedEvent struct {
+	UserOrderID uuid.UUID `json:"user_order_id"`
+	PaymentID   uuid.UUID `json:"payment_id"`
+	UserID      string    `json:"user_id"`
+	ProductID   string    `json:"product_id"`
+	Quantity    int       `json:"quantity"`
+	Attempt     int       `json:"attempt"`
+	Error       string    `json:"error"`
+	FailedAt    time.Time `json:"failed_at"`
+}
+
+// Emitted by payment-worker when the payment is given up and stored in the
+// dlx table, the order is cancelled
+type PaymentDeadLetteredEvent struct {
+	UserOrderID uuid.UUID `json:"user_order_id"`
+	PaymentID   uuid.UUID `json:"payment_id"`
+	UserID      string    `json:"user_id"`
+	ProductID   string    `json:"product_id"`
+	Quantity    int       `json:"quantity"`
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error"`
+	FailedAt    time.Time `json:"failed_at"`
+}
+
+// Asks payment-worker to refund a payment if it was charged
+type PaymentRefundRequestedCommand struct {
+	UserOrderID uuid.UUID `json:"user_order_id"`
+	PaymentID   uuid.UUID `json:"payment_id"`
+	Reason      string    `json:"reason"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+type PaymentAttemptStatus string
+
+const (
+	PaymentAttemptSucceeded PaymentAttemptStatus = "succeeded"
+	PaymentAttemptFailed    PaymentAttemptStatus = "failed"
+)
+
+type PaymentAttempt struct {
+	ID        uuid.UUID            `json:"id"`
+	PaymentID uuid.UUID            `json:"payment_id"`
+	Attempt   int                  `json:"attempt"`
+	Status    PaymentAttemptStatus `json:"status"`
+	Error     *string              `json:"error"`
+	CreatedAt time.Time            `json:"created_at"`
+}
@@ -7,8 +7,33 @@ import (
 )
 
 type Product struct {
-	ID          uuid.UUID
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Price       int64      `json:"price"` // in the smallest currency unit
+	Stock       []Stock    `json:"stock"` // per location, from the stock table
+	ArchivedAt  *time.Time `json:"archived_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Archived products stay readable but can't be ordered anymore
+func (p *Product) IsArchived() bool {
+	return p.ArchivedAt != nil
+}
+
+// ProductRequest creates or replaces a product. Stock sets the available
+// stock of the listed locations, other locations are left as they are.
+type ProductRequest struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Price       int64          `json:"price"`
+	Stock       map[string]int `json:"stock"`
+}
+
+// Products are listed by ID, which is a UUIDv7 and so sorts by creation
+type ProductFilter struct {
+	After           *uuid.UUID
+	Limit           int
+	IncludeArchived bool
 }
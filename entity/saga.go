@@ -0,0 +1,38 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SagaState string
+
+const (
+	SagaAwaitingStock   SagaState = "awaiting_stock"
+	SagaAwaitingPayment SagaState = "awaiting_payment"
+	SagaCompleted       SagaState = "completed"
+	SagaCompensating    SagaState = "compensating"
+	SagaCancelled       SagaState = "cancelled"
+)
+
+// Completed and cancelled sagas don't move anymore, except for a cancelled
+// saga compensating a step that finished late
+func (s SagaState) IsTerminal() bool {
+	return s == SagaCompleted || s == SagaCancelled
+}
+
+// Saga tracks the steps of one user order: stock, then payment. A saga
+// failing terminally or missing its deadline is compensated by releasing the
+// stock, refunding the payment and cancelling the order.
+type Saga struct {
+	UserOrderID   uuid.UUID  `json:"user_order_id"`
+	State         SagaState  `json:"state"`
+	StockReserved bool       `json:"stock_reserved"` // released again by the compensation
+	PaymentID     *uuid.UUID `json:"payment_id"`
+	Error         string     `json:"error"` // why the saga is compensated
+	Deadline      time.Time  `json:"deadline"`
+	Version       int        `json:"version"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
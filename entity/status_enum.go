@@ -1,9 +1,47 @@
 package entity
 
+import "errors"
+
 type Status string
 
 const (
-	StatusPending   Status = "pending"
-	StatusPurchased Status = "purchased"
-	StatusCancelled Status = "cancelled"
+	StatusPending           Status = "pending"
+	StatusPaymentProcessing Status = "payment_processing"
+	StatusPurchased         Status = "purchased"
+	StatusPaymentFailed     Status = "payment_failed"
+	StatusCancelled         Status = "cancelled"
 )
+
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// Order state machine, a failed payment goes back to processing on retry.
+// Cancelled is final, only dlx-replay reopens an order (see ReplayDLX).
+var statusTransitions = map[Status][]Status{
+	StatusPending:           {StatusPaymentProcessing, StatusCancelled},
+	StatusPaymentProcessing: {StatusPurchased, StatusPaymentFailed, StatusCancelled},
+	StatusPaymentFailed:     {StatusPaymentProcessing, StatusCancelled},
+	StatusPurchased:         {},
+	StatusCancelled:         {},
+}
+
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Statuses an order may be in before moving to the given status
+func StatusesAllowedBefore(next Status) []Status {
+	var statuses []Status
+	for status, allowed := range statusTransitions {
+		for _, s := range allowed {
+			if s == next {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	return statuses
+}
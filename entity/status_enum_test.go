@@ -0,0 +1,20 @@
+package entity
+
+import "testing"
+
+// nothing leaves a final status, replay reopens cancelled orders on its own
+func TestFinalStatuses(t *testing.T) {
+	statuses := []Status{StatusPending, StatusPaymentProcessing, StatusPurchased, StatusPaymentFailed, StatusCancelled}
+	for _, final := range []Status{StatusPurchased, StatusCancelled} {
+		for _, next := range statuses {
+			if final.CanTransitionTo(next) {
+				t.Errorf("%s can move to %s", final, next)
+			}
+		}
+	}
+
+	before := StatusesAllowedBefore(StatusPaymentFailed)
+	if len(before) != 1 || before[0] != StatusPaymentProcessing {
+		t.Errorf("statuses before %s = %v, want only %s", StatusPaymentFailed, before, StatusPaymentProcessing)
+	}
+}
@@ -0,0 +1,56 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stock of a product at one location
+type Stock struct {
+	ProductID string    `json:"product_id"`
+	Location  string    `json:"location"`
+	Available int       `json:"available"`
+	Reserved  int       `json:"reserved"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ReservationStatus string
+
+const (
+	ReservationReserved     ReservationStatus = "reserved"
+	ReservationInsufficient ReservationStatus = "insufficient"
+	ReservationReleased     ReservationStatus = "released"
+)
+
+// One reservation per user order, a redelivered order gets the stored outcome
+type StockReservation struct {
+	UserOrderID uuid.UUID         `json:"user_order_id"`
+	ProductID   string            `json:"product_id"`
+	Location    string            `json:"location"`
+	Quantity    int               `json:"quantity"`
+	Status      ReservationStatus `json:"status"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// Emitted by inventory-worker once the stock of an order is reserved,
+// payment starts on it
+type StockReservedEvent struct {
+	UserOrderID uuid.UUID `json:"user_order_id"`
+	UserID      string    `json:"user_id"`
+	ProductID   string    `json:"product_id"`
+	Quantity    int       `json:"quantity"`
+	Location    string    `json:"location"`
+	ReservedAt  time.Time `json:"reserved_at"`
+}
+
+// Emitted by inventory-worker when an order can't be served, the order is
+// cancelled
+type StockInsufficientEvent struct {
+	UserOrderID uuid.UUID `json:"user_order_id"`
+	UserID      string    `json:"user_id"`
+	ProductID   string    `json:"product_id"`
+	Quantity    int       `json:"quantity"`
+	Location    string    `json:"location"`
+	Available   int       `json:"available"`
+}
@@ -7,7 +7,7 @@ import (
 )
 
 type UserOrder struct {
-	ID        uuid.UUID `json:"omitempty"`
+	ID        uuid.UUID `json:"id"`
 	UserID    string    `json:"user_id"`
 	ProductID string    `json:"product_id"`
 	Quantity  int       `json:"quantity"`
@@ -17,9 +17,31 @@ type UserOrder struct {
 }
 
 type UserOrderRequest struct {
-	ID        uuid.UUID `json:"omitempty"`
+	ID        uuid.UUID `json:"id"`
 	UserID    string    `json:"user_id"`
 	ProductID string    `json:"product_id"`
 	Quantity  int       `json:"quantity"`
 	Location  string    `json:"location"`
 }
+
+type UserOrderStatus struct {
+	ID           uuid.UUID `json:"id"`
+	UserID       string    `json:"user_id"`
+	ProductID    string    `json:"product_id"`
+	Quantity     int       `json:"quantity"`
+	Location     string    `json:"location"`
+	Status       Status    `json:"status"`
+	CreatedAt    time.Time `json:"created_at"`
+	PaymentID    *string   `json:"payment_id"`
+	RetryHistory []DLX     `json:"retry_history"`
+}
+
+// Emitted by user-order-worker once the user order is persisted
+type OrderCreatedEvent struct {
+	UserOrderID uuid.UUID `json:"user_order_id"`
+	UserID      string    `json:"user_id"`
+	ProductID   string    `json:"product_id"`
+	Quantity    int       `json:"quantity"`
+	Location    string    `json:"location"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"order_processing/entity"
+)
+
+// FakeGateway is a deterministic gateway for tests. Charges succeed unless an
+// outcome was scripted for the payment with Script.
+type FakeGateway struct {
+	mu       sync.Mutex
+	outcomes map[string][]error
+	statuses map[string]Status
+	charges  map[string]int
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		outcomes: map[string][]error{},
+		statuses: map[string]Status{},
+		charges:  map[string]int{},
+	}
+}
+
+// Script queues the results of the next charges of a payment, a nil error
+// is a successful charge
+func (fg *FakeGateway) Script(paymentID string, outcomes ...error) {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	fg.outcomes[paymentID] = append(fg.outcomes[paymentID], outcomes...)
+}
+
+// Charges returns how many times a payment was charged, retries of a
+// charged payment don't count
+func (fg *FakeGateway) Charges(paymentID string) int {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.charges[paymentID]
+}
+
+func (fg *FakeGateway) Charge(ctx context.Context, payment *entity.Payment) error {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+
+	paymentID := payment.ID.String()
+	if fg.statuses[paymentID] == StatusCharged {
+		return nil
+	}
+	fg.charges[paymentID]++
+
+	var err error
+	if outcomes := fg.outcomes[paymentID]; len(outcomes) > 0 {
+		err = outcomes[0]
+		fg.outcomes[paymentID] = outcomes[1:]
+	}
+	if err != nil {
+		fg.statuses[paymentID] = StatusFailed
+		return err
+	}
+	fg.statuses[paymentID] = StatusCharged
+	return nil
+}
+
+func (fg *FakeGateway) Refund(ctx context.Context, payment *entity.Payment) error {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+
+	paymentID := payment.ID.String()
+	switch fg.statuses[paymentID] {
+	case StatusCharged, StatusRefunded:
+		fg.statuses[paymentID] = StatusRefunded
+		return nil
+	default:
+		return Terminal(errors.New("payment was not charged"))
+	}
+}
+
+func (fg *FakeGateway) GetStatus(ctx context.Context, paymentID string) (Status, error) {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+
+	status, ok := fg.statuses[paymentID]
+	if !ok {
+		return StatusUnknown, nil
+	}
+	return status, nil
+}
@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"order_processing/entity"
+)
+
+type Status string
+
+const (
+	StatusUnknown  Status = "unknown"
+	StatusCharged  Status = "charged"
+	StatusFailed   Status = "failed"
+	StatusRefunded Status = "refunded"
+)
+
+// PaymentGateway charges payments. Charge and Refund must be idempotent per
+// payment ID since the worker retries them.
+type PaymentGateway interface {
+	Charge(ctx context.Context, payment *entity.Payment) error
+	Refund(ctx context.Context, payment *entity.Payment) error
+	GetStatus(ctx context.Context, paymentID string) (Status, error)
+}
+
+// Error tells the worker whether a failed call is worth retrying
+type Error struct {
+	Retryable bool
+	Err       error
+}
+
+func (e *Error) Error() string {
+	if e.Retryable {
+		return fmt.Sprintf("retryable gateway error: %v", e.Err)
+	}
+	return fmt.Sprintf("terminal gateway error: %v", e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func Retryable(err error) error {
+	return &Error{Retryable: true, Err: err}
+}
+
+func Terminal(err error) error {
+	return &Error{Retryable: false, Err: err}
+}
+
+// Errors that aren't classified by the gateway, like timeouts, are retried
+func IsRetryable(err error) bool {
+	var gatewayErr *Error
+	if errors.As(err, &gatewayErr) {
+		return gatewayErr.Retryable
+	}
+	return true
+}
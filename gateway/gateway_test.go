@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+)
+
+func newPayment() *entity.Payment {
+	return &entity.Payment{ID: uuid.Must(uuid.NewV7())}
+}
+
+func TestSimulatorScenarios(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		scenario   string
+		wantErrs   []bool // whether each charge fails
+		wantStatus Status
+	}{
+		{scenario: "success", wantErrs: []bool{false, false, false}, wantStatus: StatusCharged},
+		{scenario: "retry", wantErrs: []bool{true, false, false}, wantStatus: StatusCharged},
+		{scenario: "dlx", wantErrs: []bool{true, true, true}, wantStatus: StatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scenario, func(t *testing.T) {
+			sg := NewSimulatorGateway(0)
+			picked := 0
+			sg.pickScenario = func() string {
+				picked++
+				return tt.scenario
+			}
+			payment := newPayment()
+
+			// charges after the first success must not pick a new scenario
+			// or charge again
+			for i, wantErr := range tt.wantErrs {
+				err := sg.Charge(ctx, payment)
+				if (err != nil) != wantErr {
+					t.Fatalf("charge %d = %v, want error %v", i+1, err, wantErr)
+				}
+				if err != nil && !IsRetryable(err) {
+					t.Fatalf("charge %d = %v, want a retryable error", i+1, err)
+				}
+			}
+			if picked != 1 {
+				t.Errorf("picked %d scenarios, want 1", picked)
+			}
+			if status, _ := sg.GetStatus(ctx, payment.ID.String()); status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestFakeGateway(t *testing.T) {
+	ctx := context.Background()
+	errTimeout := errors.New("gateway timeout")
+
+	tests := []struct {
+		name        string
+		outcomes    []error
+		charges     int
+		wantErrs    int
+		wantCharges int
+		wantStatus  Status
+	}{
+		{name: "succeeds by default", charges: 1, wantCharges: 1, wantStatus: StatusCharged},
+		{name: "scripted failure", outcomes: []error{Terminal(errTimeout)}, charges: 1, wantErrs: 1, wantCharges: 1, wantStatus: StatusFailed},
+		{name: "succeeds after retry", outcomes: []error{Retryable(errTimeout)}, charges: 2, wantErrs: 1, wantCharges: 2, wantStatus: StatusCharged},
+		{name: "charged once", charges: 3, wantCharges: 1, wantStatus: StatusCharged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fg := NewFakeGateway()
+			payment := newPayment()
+			fg.Script(payment.ID.String(), tt.outcomes...)
+
+			errs := 0
+			for range tt.charges {
+				if err := fg.Charge(ctx, payment); err != nil {
+					errs++
+				}
+			}
+			if errs != tt.wantErrs {
+				t.Errorf("failed charges = %d, want %d", errs, tt.wantErrs)
+			}
+			if got := fg.Charges(payment.ID.String()); got != tt.wantCharges {
+				t.Errorf("charges = %d, want %d", got, tt.wantCharges)
+			}
+			if status, _ := fg.GetStatus(ctx, payment.ID.String()); status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestFakeGatewayRefund(t *testing.T) {
+	ctx := context.Background()
+	fg := NewFakeGateway()
+	payment := newPayment()
+
+	if err := fg.Refund(ctx, payment); IsRetryable(err) || err == nil {
+		t.Fatalf("refund of an uncharged payment = %v, want a terminal error", err)
+	}
+	if err := fg.Charge(ctx, payment); err != nil {
+		t.Fatalf("charge: %v", err)
+	}
+	for range 2 {
+		if err := fg.Refund(ctx, payment); err != nil {
+			t.Fatalf("refund: %v", err)
+		}
+	}
+	if status, _ := fg.GetStatus(ctx, payment.ID.String()); status != StatusRefunded {
+		t.Fatalf("status = %s, want %s", status, StatusRefunded)
+	}
+}
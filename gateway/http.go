@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"order_processing/entity"
+)
+
+// HTTPGateway talks to a payment gateway over a small REST API:
+//
+//	POST {baseURL}/charges                {"payment_id", "user_order_id"}
+//	POST {baseURL}/charges/{id}/refund
+//	GET  {baseURL}/charges/{id}           -> {"status"}
+//
+// The payment ID is sent as Idempotency-Key so retried calls are safe.
+type HTTPGateway struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewHTTPGateway(baseURL string, timeout time.Duration) *HTTPGateway {
+	return &HTTPGateway{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+type chargeRequest struct {
+	PaymentID   string `json:"payment_id"`
+	UserOrderID string `json:"user_order_id"`
+}
+
+type statusResponse struct {
+	Status Status `json:"status"`
+}
+
+func (hg *HTTPGateway) Charge(ctx context.Context, payment *entity.Payment) error {
+	body, err := json.Marshal(chargeRequest{
+		PaymentID:   payment.ID.String(),
+		UserOrderID: payment.UserOrderID,
+	})
+	if err != nil {
+		return Terminal(err)
+	}
+
+	res, err := hg.do(ctx, http.MethodPost, "/charges", payment.ID.String(), body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// 409 means the payment was already charged by an earlier attempt
+	if res.StatusCode == http.StatusConflict {
+		return nil
+	}
+	return classify(res)
+}
+
+func (hg *HTTPGateway) Refund(ctx context.Context, payment *entity.Payment) error {
+	path := "/charges/" + url.PathEscape(payment.ID.String()) + "/refund"
+	res, err := hg.do(ctx, http.MethodPost, path, payment.ID.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return classify(res)
+}
+
+func (hg *HTTPGateway) GetStatus(ctx context.Context, paymentID string) (Status, error) {
+	res, err := hg.do(ctx, http.MethodGet, "/charges/"+url.PathEscape(paymentID), "", nil)
+	if err != nil {
+		return StatusUnknown, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return StatusUnknown, nil
+	}
+	if err := classify(res); err != nil {
+		return StatusUnknown, err
+	}
+
+	var status statusResponse
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return StatusUnknown, Retryable(fmt.Errorf("decode status response: %w", err))
+	}
+	return status.Status, nil
+}
+
+func (hg *HTTPGateway) do(ctx context.Context, method, path, idempotencyKey string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, hg.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, Terminal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	res, err := hg.client.Do(req)
+	if err != nil {
+		// connection refused, reset and timeouts are all worth retrying
+		return nil, Retryable(err)
+	}
+	return res, nil
+}
+
+// 5xx, 408 and 429 are retryable, any other non 2xx status is terminal
+func classify(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	err := fmt.Errorf("gateway responded %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	switch {
+	case res.StatusCode >= 500,
+		res.StatusCode == http.StatusRequestTimeout,
+		res.StatusCode == http.StatusTooManyRequests:
+		return Retryable(err)
+	default:
+		return Terminal(err)
+	}
+}
@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPGatewayCharge(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		delay         time.Duration
+		wantErr       bool
+		wantRetryable bool
+	}{
+		{name: "charged", status: http.StatusCreated},
+		{name: "already charged", status: http.StatusConflict},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true, wantRetryable: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantErr: true, wantRetryable: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantErr: true, wantRetryable: true},
+		{name: "request timeout", status: http.StatusRequestTimeout, wantErr: true, wantRetryable: true},
+		{name: "declined", status: http.StatusPaymentRequired, wantErr: true},
+		{name: "bad request", status: http.StatusBadRequest, wantErr: true},
+		{name: "client timeout", status: http.StatusCreated, delay: time.Second, wantErr: true, wantRetryable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment := newPayment()
+			payment.UserOrderID = "order-1"
+
+			var got chargeRequest
+			var idempotencyKey string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/charges" {
+					t.Errorf("request %s %s, want POST /charges", r.Method, r.URL.Path)
+				}
+				idempotencyKey = r.Header.Get("Idempotency-Key")
+				json.NewDecoder(r.Body).Decode(&got)
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+					return
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := NewHTTPGateway(server.URL+"/", 200*time.Millisecond).Charge(context.Background(), payment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("charge = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if IsRetryable(err) != tt.wantRetryable {
+					t.Errorf("charge = %v, want retryable %v", err, tt.wantRetryable)
+				}
+				return
+			}
+			if idempotencyKey != payment.ID.String() {
+				t.Errorf("Idempotency-Key = %q, want the payment ID %s", idempotencyKey, payment.ID)
+			}
+			if got.PaymentID != payment.ID.String() || got.UserOrderID != payment.UserOrderID {
+				t.Errorf("charge request = %+v", got)
+			}
+		})
+	}
+}
+
+func TestHTTPGatewayUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	err := NewHTTPGateway(server.URL, time.Second).Charge(context.Background(), newPayment())
+	if err == nil || !IsRetryable(err) {
+		t.Fatalf("charge through a closed server = %v, want a retryable error", err)
+	}
+}
+
+func TestHTTPGatewayGetStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus Status
+		wantErr    bool
+	}{
+		{name: "charged", status: http.StatusOK, body: `{"status":"charged"}`, wantStatus: StatusCharged},
+		{name: "unknown payment", status: http.StatusNotFound, wantStatus: StatusUnknown},
+		{name: "server error", status: http.StatusBadGateway, wantStatus: StatusUnknown, wantErr: true},
+		{name: "malformed body", status: http.StatusOK, body: `{`, wantStatus: StatusUnknown, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment := newPayment()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/charges/"+payment.ID.String() {
+					t.Errorf("path = %s", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			status, err := NewHTTPGateway(server.URL, time.Second).GetStatus(context.Background(), payment.ID.String())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("get status = %v, want error %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
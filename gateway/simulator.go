@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"order_processing/entity"
+)
+
+// SimulatorGateway randomly picks a scenario per payment and plays it out
+// across retries:
+//   - success: succeeds on the first attempt
+//   - retry: fails the first attempt, succeeds after
+//   - dlx: fails every attempt
+type SimulatorGateway struct {
+	latency time.Duration
+	// picks the scenario of a new payment, random unless a test sets it
+	pickScenario func() string
+
+	mu        sync.Mutex
+	scenarios map[string]string // paymentID -> scenario type
+	attempts  map[string]int    // paymentID -> charge attempts so far
+	statuses  map[string]Status
+}
+
+func NewSimulatorGateway(latency time.Duration) *SimulatorGateway {
+	return &SimulatorGateway{
+		latency:      latency,
+		pickScenario: randomScenario,
+		scenarios:    map[string]string{},
+		attempts:     map[string]int{},
+		statuses:     map[string]Status{},
+	}
+}
+
+func (sg *SimulatorGateway) Charge(ctx context.Context, payment *entity.Payment) error {
+	paymentID := payment.ID.String()
+
+	// a retried charge of a charged payment must not charge it twice
+	if status, _ := sg.GetStatus(ctx, paymentID); status == StatusCharged {
+		log.Printf("✅ Payment %s was charged already", paymentID)
+		return nil
+	}
+
+	log.Printf("💳 Starting payment service for payment ID: %s", paymentID)
+
+	// payment logic takes a while
+	select {
+	case <-time.After(sg.latency):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	// Get or assign scenario for this payment
+	scenario, exists := sg.scenarios[paymentID]
+	if !exists {
+		scenario = sg.pickScenario()
+		sg.scenarios[paymentID] = scenario
+		log.Printf("🎲 Assigned scenario: %s", strings.ToUpper(scenario))
+	}
+	attempt := sg.attempts[paymentID]
+	sg.attempts[paymentID] = attempt + 1
+
+	// Execute based on scenario
+	switch scenario {
+	case "success":
+		log.Println("✅ [SCENARIO: SUCCESS] Payment processed successfully!")
+		sg.settle(paymentID, StatusCharged)
+		return nil
+
+	case "retry":
+		if attempt < 1 {
+			log.Println("⚠️  [SCENARIO: RETRY] Payment gateway timeout - will retry")
+			return Retryable(errors.New("payment service error: gateway timeout"))
+		}
+		log.Println("✅ [SCENARIO: RETRY] Payment succeeded after retry!")
+		sg.settle(paymentID, StatusCharged)
+		return nil
+
+	case "dlx":
+		log.Printf("❌ [SCENARIO: DLX] Payment failed (attempt %d)", attempt+1)
+		sg.statuses[paymentID] = StatusFailed
+		return Retryable(errors.New("payment service error: gateway unavailable"))
+
+	default:
+		return Terminal(errors.New("unknown scenario"))
+	}
+}
+
+func randomScenario() string {
+	scenarios := []string{"success", "retry", "dlx"}
+	return scenarios[rand.Intn(len(scenarios))]
+}
+
+// settle records the final status and forgets the scenario
+func (sg *SimulatorGateway) settle(paymentID string, status Status) {
+	sg.statuses[paymentID] = status
+	delete(sg.scenarios, paymentID)
+	delete(sg.attempts, paymentID)
+}
+
+func (sg *SimulatorGateway) Refund(ctx context.Context, payment *entity.Payment) error {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	paymentID := payment.ID.String()
+	switch sg.statuses[paymentID] {
+	case StatusCharged, StatusRefunded:
+		sg.statuses[paymentID] = StatusRefunded
+		return nil
+	default:
+		return Terminal(errors.New("payment was not charged"))
+	}
+}
+
+func (sg *SimulatorGateway) GetStatus(ctx context.Context, paymentID string) (Status, error) {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	status, ok := sg.statuses[paymentID]
+	if !ok {
+		return StatusUnknown, nil
+	}
+	return status, nil
+}
@@ -0,0 +1,117 @@
+// Package messaging wraps every message in a typed, versioned envelope. The
+// envelope and its payload are validated against JSON Schemas on publish and
+// on consume, so a consumer never works on a message it doesn't understand.
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message types, also used as the AMQP type property
+const (
+	TypeUserOrderRequested  = "user_order.requested"
+	TypeOrderCreated        = "order.created"
+	TypeStockReserved       = "stock.reserved"
+	TypeStockInsufficient   = "stock.insufficient"
+	TypePaymentSucceeded    = "payment.succeeded"
+	TypePaymentFailed       = "payment.failed"
+	TypePaymentDeadLettered = "payment.dead_lettered"
+	// command from the saga to payment-worker
+	TypePaymentRefundRequested = "payment.refund_requested"
+)
+
+var (
+	ErrInvalidMessage     = errors.New("invalid message")
+	ErrUnknownType        = errors.New("unknown message type")
+	ErrUnsupportedVersion = errors.New("unsupported message version")
+)
+
+type Envelope struct {
+	Type          string          `json:"type"`
+	Version       int             `json:"version"`
+	MessageID     uuid.UUID       `json:"message_id"`
+	CorrelationID string          `json:"correlation_id"` // ties every message of one user order together
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// New wraps payload in an envelope of the latest version of msgType
+func New(msgType, correlationID string, payload any) (*Envelope, error) {
+	version, ok := latestVersion(msgType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, msgType)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	messageID, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		Type:          msgType,
+		Version:       version,
+		MessageID:     messageID,
+		CorrelationID: correlationID,
+		OccurredAt:    time.Now().UTC(),
+		Payload:       body,
+	}, nil
+}
+
+// Marshal validates the envelope and its payload and encodes them
+func (e *Envelope) Marshal() ([]byte, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(envelopeSchema, body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	if err := e.validatePayload(); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Decode validates body as an envelope of msgType and decodes its payload
+// into v. Every error is permanent, retrying the message can't help.
+func Decode(body []byte, msgType string, v any) (*Envelope, error) {
+	if err := validate(envelopeSchema, body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	if envelope.Type != msgType {
+		return nil, fmt.Errorf("%w: %s, want %s", ErrUnknownType, envelope.Type, msgType)
+	}
+	if err := envelope.validatePayload(); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(envelope.Payload, v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	return &envelope, nil
+}
+
+func (e *Envelope) validatePayload() error {
+	versions, ok := payloadSchemas[e.Type]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownType, e.Type)
+	}
+	schema, ok := versions[e.Version]
+	if !ok {
+		return fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, e.Type, e.Version)
+	}
+	if err := validate(schema, e.Payload); err != nil {
+		return fmt.Errorf("%w: %s v%d payload: %v", ErrInvalidMessage, e.Type, e.Version, err)
+	}
+	return nil
+}
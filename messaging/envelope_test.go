@@ -0,0 +1,123 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+)
+
+func refundCommand() entity.PaymentRefundRequestedCommand {
+	return entity.PaymentRefundRequestedCommand{
+		UserOrderID: uuid.New(),
+		PaymentID:   uuid.New(),
+		Reason:      "saga timed out",
+		RequestedAt: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	command := refundCommand()
+	envelope, err := New(TypePaymentRefundRequested, command.UserOrderID.String(), command)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	body, err := envelope.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var decoded entity.PaymentRefundRequestedCommand
+	got, err := Decode(body, TypePaymentRefundRequested, &decoded)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Type != TypePaymentRefundRequested || got.Version != 1 {
+		t.Errorf("decoded %s v%d, want %s v1", got.Type, got.Version, TypePaymentRefundRequested)
+	}
+	if got.MessageID != envelope.MessageID || got.CorrelationID != command.UserOrderID.String() {
+		t.Errorf("decoded message %s of %s, want %s of %s", got.MessageID, got.CorrelationID, envelope.MessageID, command.UserOrderID)
+	}
+	if !decoded.RequestedAt.Equal(command.RequestedAt) {
+		t.Errorf("requested at = %s, want %s", decoded.RequestedAt, command.RequestedAt)
+	}
+	decoded.RequestedAt = command.RequestedAt
+	if decoded != command {
+		t.Errorf("payload = %+v, want %+v", decoded, command)
+	}
+}
+
+func TestNewAndMarshalReject(t *testing.T) {
+	if _, err := New("order.shipped", "c", map[string]any{}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("new of an unknown type = %v, want %v", err, ErrUnknownType)
+	}
+
+	envelope, err := New(TypePaymentRefundRequested, "c", map[string]any{"reason": "no payment id"})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if _, err := envelope.Marshal(); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("marshal of an invalid payload = %v, want %v", err, ErrInvalidMessage)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	command := refundCommand()
+	payload, err := json.Marshal(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a valid envelope, changed by every case
+	envelope := func(change func(map[string]any)) []byte {
+		fields := map[string]any{
+			"type":           TypePaymentRefundRequested,
+			"version":        1,
+			"message_id":     uuid.NewString(),
+			"correlation_id": command.UserOrderID.String(),
+			"occurred_at":    time.Now().UTC().Format(time.RFC3339Nano),
+			"payload":        json.RawMessage(payload),
+		}
+		change(fields)
+		body, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	tests := []struct {
+		name    string
+		body    []byte
+		msgType string
+		wantErr error
+	}{
+		{name: "not json", body: []byte(`{"type":`), wantErr: ErrInvalidMessage},
+		{name: "no envelope", body: payload, wantErr: ErrInvalidMessage},
+		{name: "missing message id", body: envelope(func(f map[string]any) { delete(f, "message_id") }), wantErr: ErrInvalidMessage},
+		{name: "malformed message id", body: envelope(func(f map[string]any) { f["message_id"] = "42" }), wantErr: ErrInvalidMessage},
+		{name: "unknown envelope field", body: envelope(func(f map[string]any) { f["priority"] = 1 }), wantErr: ErrInvalidMessage},
+		{name: "other type", body: envelope(func(map[string]any) {}), msgType: TypePaymentSucceeded, wantErr: ErrUnknownType},
+		{name: "unregistered type", body: envelope(func(f map[string]any) { f["type"] = "order.shipped" }), msgType: "order.shipped", wantErr: ErrUnknownType},
+		{name: "unknown version", body: envelope(func(f map[string]any) { f["version"] = 2 }), wantErr: ErrUnsupportedVersion},
+		{name: "payload missing field", body: envelope(func(f map[string]any) { f["payload"] = map[string]any{"reason": "x"} }), wantErr: ErrInvalidMessage},
+		{name: "payload malformed uuid", body: envelope(func(f map[string]any) {
+			f["payload"] = map[string]any{"user_order_id": "1", "payment_id": command.PaymentID, "reason": "x", "requested_at": command.RequestedAt}
+		}), wantErr: ErrInvalidMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgType := tt.msgType
+			if msgType == "" {
+				msgType = TypePaymentRefundRequested
+			}
+			var v entity.PaymentRefundRequestedCommand
+			if _, err := Decode(tt.body, msgType, &v); !errors.Is(err, tt.wantErr) {
+				t.Errorf("decode = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package messaging
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publishing validates and encodes the envelope as a persistent AMQP message
+// carrying its type, message ID and correlation ID as properties
+func (e *Envelope) Publishing() (amqp.Publishing, error) {
+	body, err := e.Marshal()
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	return amqp.Publishing{
+		ContentType:   "application/json",
+		Type:          e.Type,
+		MessageId:     e.MessageID.String(),
+		CorrelationId: e.CorrelationID,
+		Timestamp:     e.OccurredAt,
+		Body:          body,
+		DeliveryMode:  amqp.Persistent,
+	}, nil
+}
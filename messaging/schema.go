@@ -0,0 +1,82 @@
+package messaging
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+const schemaBaseURL = "https://order-processing.local/schemas/"
+
+// payload schemas by type and version, a new version adds a
+// schemas/{type}.v{version}.json file and an entry here
+var payloadVersions = map[string][]int{
+	TypeUserOrderRequested:     {1},
+	TypeOrderCreated:           {1},
+	TypeStockReserved:          {1},
+	TypeStockInsufficient:      {1},
+	TypePaymentSucceeded:       {1},
+	TypePaymentFailed:          {1},
+	TypePaymentDeadLettered:    {1},
+	TypePaymentRefundRequested: {1},
+}
+
+var (
+	envelopeSchema = mustCompile("envelope.json")
+	payloadSchemas = compilePayloadSchemas()
+)
+
+func compilePayloadSchemas() map[string]map[int]*jsonschema.Schema {
+	schemas := map[string]map[int]*jsonschema.Schema{}
+	for msgType, versions := range payloadVersions {
+		schemas[msgType] = map[int]*jsonschema.Schema{}
+		for _, version := range versions {
+			schemas[msgType][version] = mustCompile(fmt.Sprintf("%s.v%d.json", msgType, version))
+		}
+	}
+	return schemas
+}
+
+func mustCompile(name string) *jsonschema.Schema {
+	data, err := schemaFiles.ReadFile(path.Join("schemas", name))
+	if err != nil {
+		panic(err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		panic(fmt.Sprintf("schema %s: %v", name, err))
+	}
+
+	// an absolute URL, a bare name would be resolved against the working directory
+	url := schemaBaseURL + name
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	if err := compiler.AddResource(url, doc); err != nil {
+		panic(fmt.Sprintf("schema %s: %v", name, err))
+	}
+	return compiler.MustCompile(url)
+}
+
+// latestVersion is the version new messages of a type are published with
+func latestVersion(msgType string) (int, bool) {
+	versions := payloadVersions[msgType]
+	if len(versions) == 0 {
+		return 0, false
+	}
+	return versions[len(versions)-1], true
+}
+
+// validate checks raw JSON against a schema
+func validate(schema *jsonschema.Schema, data []byte) error {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return schema.Validate(doc)
+}
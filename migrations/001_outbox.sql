@@ -0,0 +1,14 @@
+-- outbox rows are written in the same transaction as the user order
+-- and published by workers/outbox-relay
+CREATE TABLE IF NOT EXISTS outbox (
+    id          UUID PRIMARY KEY,
+    exchange    TEXT        NOT NULL,
+    routing_key TEXT        NOT NULL,
+    payload     JSONB       NOT NULL,
+    attempts    INT         NOT NULL DEFAULT 0,
+    last_error  TEXT,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at     TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (created_at) WHERE sent_at IS NULL;
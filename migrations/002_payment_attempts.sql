@@ -0,0 +1,27 @@
+-- one payment per user order, retries are recorded as payment attempts.
+-- keep the oldest payment of each user order, dlx records of the dropped
+-- duplicates are moved to it
+WITH ranked AS (
+    SELECT id, first_value(id) OVER (PARTITION BY user_order_id ORDER BY created_at, id) AS kept_id
+    FROM payments
+)
+UPDATE dlx SET payment_id = ranked.kept_id
+FROM ranked
+WHERE dlx.payment_id = ranked.id AND ranked.id <> ranked.kept_id;
+
+DELETE FROM payments p
+USING payments kept
+WHERE p.user_order_id = kept.user_order_id
+  AND (kept.created_at, kept.id) < (p.created_at, p.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS payments_user_order_id_key ON payments (user_order_id);
+
+CREATE TABLE IF NOT EXISTS payment_attempts (
+    id         UUID PRIMARY KEY,
+    payment_id UUID        NOT NULL REFERENCES payments (id),
+    attempt    INT         NOT NULL,
+    status     TEXT        NOT NULL,
+    error      TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (payment_id, attempt)
+);
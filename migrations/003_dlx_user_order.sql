@@ -0,0 +1,3 @@
+-- dlx records of user-order-worker have no payment yet
+ALTER TABLE dlx ADD COLUMN IF NOT EXISTS user_order_id UUID;
+ALTER TABLE dlx ALTER COLUMN payment_id DROP NOT NULL;
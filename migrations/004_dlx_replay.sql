@@ -0,0 +1,2 @@
+-- set by dlx-replay once a record has been republished
+ALTER TABLE dlx ADD COLUMN IF NOT EXISTS replayed_at TIMESTAMPTZ;
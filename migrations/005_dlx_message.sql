@@ -0,0 +1,6 @@
+-- the dead lettered message itself, so it can be inspected and replayed as it was
+ALTER TABLE dlx ADD COLUMN IF NOT EXISTS payload JSONB;
+ALTER TABLE dlx ADD COLUMN IF NOT EXISTS headers JSONB;
+ALTER TABLE dlx ADD COLUMN IF NOT EXISTS routing_key TEXT;
+ALTER TABLE dlx ADD COLUMN IF NOT EXISTS source_queue TEXT;
+ALTER TABLE dlx ADD COLUMN IF NOT EXISTS history JSONB;
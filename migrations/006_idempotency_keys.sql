@@ -0,0 +1,12 @@
+-- responses of POST /order by Idempotency-Key, written with the user order
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key             TEXT PRIMARY KEY,
+    request_hash    TEXT        NOT NULL,
+    user_order_id   UUID        NOT NULL,
+    response_status INT         NOT NULL,
+    response_body   JSONB       NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,19 @@
+-- stock per product and location, reserved by inventory-worker
+CREATE TABLE IF NOT EXISTS stock (
+    product_id TEXT        NOT NULL,
+    location   TEXT        NOT NULL,
+    available  INT         NOT NULL DEFAULT 0 CHECK (available >= 0),
+    reserved   INT         NOT NULL DEFAULT 0 CHECK (reserved >= 0),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (product_id, location)
+);
+
+-- one reservation per user order keeps redeliveries from reserving twice
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    user_order_id UUID PRIMARY KEY,
+    product_id    TEXT        NOT NULL,
+    location      TEXT        NOT NULL,
+    quantity      INT         NOT NULL,
+    status        TEXT        NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
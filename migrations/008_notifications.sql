@@ -0,0 +1,11 @@
+-- notifications sent by notification-worker, keyed by an ID derived from the event
+CREATE TABLE IF NOT EXISTS notifications (
+    event_id      UUID PRIMARY KEY,
+    type          TEXT        NOT NULL,
+    user_id       TEXT        NOT NULL,
+    user_order_id UUID        NOT NULL,
+    subject       TEXT        NOT NULL,
+    sent_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_order_id_idx ON notifications (user_order_id);
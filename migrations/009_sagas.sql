@@ -0,0 +1,16 @@
+-- one saga per user order, advanced by saga-orchestrator
+CREATE TABLE IF NOT EXISTS sagas (
+    user_order_id  UUID PRIMARY KEY,
+    state          TEXT        NOT NULL,
+    stock_reserved BOOLEAN     NOT NULL DEFAULT false,
+    payment_id     UUID,
+    error          TEXT        NOT NULL DEFAULT '',
+    deadline       TIMESTAMPTZ NOT NULL,
+    version        INT         NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- the sweeper looks up sagas past their deadline that aren't finished
+CREATE INDEX IF NOT EXISTS sagas_deadline_idx ON sagas (deadline)
+    WHERE state NOT IN ('completed', 'cancelled');
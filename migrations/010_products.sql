@@ -0,0 +1,10 @@
+-- product catalog, its stock per location lives in the stock table
+CREATE TABLE IF NOT EXISTS products (
+    id          UUID PRIMARY KEY,
+    name        TEXT        NOT NULL,
+    description TEXT        NOT NULL DEFAULT '',
+    price       BIGINT      NOT NULL CHECK (price >= 0),
+    archived_at TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,6 @@
+-- set by outbox-relay once a row has failed OUTBOX_MAX_ATTEMPTS times, dead
+-- rows are no longer published
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS dead_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS outbox_pending_idx;
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (created_at) WHERE sent_at IS NULL AND dead_at IS NULL;
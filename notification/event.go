@@ -0,0 +1,35 @@
+package notification
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// namespace of the event IDs, any fixed UUID does
+var eventNamespace = uuid.MustParse("6f1c1e52-4b0e-4c55-9a43-3f3c2f0f8a51")
+
+// EventID identifies the event a notification is sent for. Message IDs are
+// new on every publish of the same event, so the ID is derived from the
+// payment, or the user order before there is one, and the event type. Every
+// failed payment attempt is an event of its own. A replayed message starts
+// its attempts over, so its events are also told apart by replayedFrom, the
+// dlx record it was replayed from, empty unless replayed.
+func EventID(msgType string, payload map[string]any, replayedFrom string) (uuid.UUID, error) {
+	subject, _ := payload["payment_id"].(string)
+	if subject == "" {
+		subject, _ = payload["user_order_id"].(string)
+	}
+	if subject == "" {
+		return uuid.Nil, fmt.Errorf("%s event has neither a payment nor a user order", msgType)
+	}
+
+	key := msgType + "/" + subject
+	if attempt, ok := payload["attempt"].(float64); ok {
+		key += fmt.Sprintf("/%d", int(attempt))
+	}
+	if replayedFrom != "" {
+		key += "/replay/" + replayedFrom
+	}
+	return uuid.NewSHA1(eventNamespace, []byte(key)), nil
+}
@@ -0,0 +1,45 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEventID(t *testing.T) {
+	paymentID := uuid.NewString()
+	userOrderID := uuid.NewString()
+	failed := func(attempt float64) map[string]any {
+		// JSON numbers decode as float64
+		return map[string]any{"payment_id": paymentID, "user_order_id": userOrderID, "attempt": attempt}
+	}
+	id := func(msgType string, payload map[string]any, replayedFrom string) uuid.UUID {
+		t.Helper()
+		eventID, err := EventID(msgType, payload, replayedFrom)
+		if err != nil {
+			t.Fatalf("EventID(%s, %v): %v", msgType, payload, err)
+		}
+		return eventID
+	}
+
+	first := id("payment.failed", failed(1), "")
+	if again := id("payment.failed", failed(1), ""); again != first {
+		t.Errorf("the same event got IDs %s and %s", first, again)
+	}
+
+	distinct := map[string]uuid.UUID{
+		"next attempt":           id("payment.failed", failed(2), ""),
+		"replayed first attempt": id("payment.failed", failed(1), uuid.NewString()),
+		"other type":             id("payment.dead_lettered", failed(1), ""),
+		"user order only":        id("payment.failed", map[string]any{"user_order_id": userOrderID, "attempt": 1.0}, ""),
+	}
+	for name, other := range distinct {
+		if other == first {
+			t.Errorf("%s got the ID of the first attempt", name)
+		}
+	}
+
+	if _, err := EventID("order.created", map[string]any{"user_id": "u1"}, ""); err == nil {
+		t.Error("event without a payment or user order got an ID")
+	}
+}
@@ -0,0 +1,20 @@
+package notification
+
+import (
+	"context"
+	"log"
+
+	"order_processing/entity"
+)
+
+// LogNotifier writes notifications to the log, for development
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (ln *LogNotifier) Notify(ctx context.Context, notification *entity.Notification) error {
+	log.Printf("📣 Notification %s for user %s: %s\n%s", notification.Type, notification.UserID, notification.Subject, notification.Body)
+	return nil
+}
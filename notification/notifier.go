@@ -0,0 +1,52 @@
+// Package notification renders order and payment events into notifications
+// and delivers them to users.
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+)
+
+// ErrAlreadySent is returned by Send for an event that was claimed before
+var ErrAlreadySent = errors.New("notification already sent")
+
+// Notifier delivers a rendered notification. Notify must be safe to call
+// again for the same notification since the worker retries failed sends.
+type Notifier interface {
+	Notify(ctx context.Context, notification *entity.Notification) error
+}
+
+// Claimer records the events notifications were sent for by their event ID,
+// the notification repository is one
+type Claimer interface {
+	ClaimNotification(ctx context.Context, notification *entity.Notification) (bool, error)
+	ReleaseNotification(ctx context.Context, eventID uuid.UUID) error
+}
+
+// Send claims the event of the notification and sends it, so an event that
+// is delivered again isn't sent twice. A failed send releases the claim for
+// the retry, a crash between the claim and the send loses the notification
+// rather than sending it twice.
+func Send(ctx context.Context, claimer Claimer, notifier Notifier, notification *entity.Notification) error {
+	claimed, err := claimer.ClaimNotification(ctx, notification)
+	if err != nil {
+		return fmt.Errorf("claim: %w", err)
+	}
+	if !claimed {
+		return ErrAlreadySent
+	}
+
+	if err := notifier.Notify(ctx, notification); err != nil {
+		// the release runs even when shutdown aborted the send
+		if releaseErr := claimer.ReleaseNotification(context.WithoutCancel(ctx), notification.EventID); releaseErr != nil {
+			return fmt.Errorf("%w, release failed so it won't be sent: %v", err, releaseErr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+)
+
+// memoryClaimer keeps claims in a map like the notifications table
+type memoryClaimer struct {
+	claimed map[uuid.UUID]bool
+}
+
+func (mc *memoryClaimer) ClaimNotification(ctx context.Context, notification *entity.Notification) (bool, error) {
+	if mc.claimed[notification.EventID] {
+		return false, nil
+	}
+	mc.claimed[notification.EventID] = true
+	return true, nil
+}
+
+func (mc *memoryClaimer) ReleaseNotification(ctx context.Context, eventID uuid.UUID) error {
+	delete(mc.claimed, eventID)
+	return nil
+}
+
+// scriptedNotifier fails with the scripted errors first, then succeeds
+type scriptedNotifier struct {
+	errs []error
+	sent int
+}
+
+func (sn *scriptedNotifier) Notify(ctx context.Context, notification *entity.Notification) error {
+	if len(sn.errs) > 0 {
+		err := sn.errs[0]
+		sn.errs = sn.errs[1:]
+		return err
+	}
+	sn.sent++
+	return nil
+}
+
+func TestSendDedupesByEventID(t *testing.T) {
+	ctx := context.Background()
+	errDown := errors.New("smtp server down")
+
+	tests := []struct {
+		name     string
+		errs     []error
+		sends    int
+		wantErrs []error
+		wantSent int
+	}{
+		{name: "sent once", sends: 3, wantErrs: []error{nil, ErrAlreadySent, ErrAlreadySent}, wantSent: 1},
+		{name: "failed send is released", errs: []error{errDown}, sends: 3, wantErrs: []error{errDown, nil, ErrAlreadySent}, wantSent: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claimer := &memoryClaimer{claimed: map[uuid.UUID]bool{}}
+			notifier := &scriptedNotifier{errs: tt.errs}
+			eventID, err := EventID("payment.succeeded", map[string]any{"payment_id": uuid.NewString()}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range tt.sends {
+				// every delivery renders its own notification for the event
+				err := Send(ctx, claimer, notifier, &entity.Notification{EventID: eventID, Type: "payment.succeeded"})
+				if !errors.Is(err, tt.wantErrs[i]) {
+					t.Errorf("send %d = %v, want %v", i+1, err, tt.wantErrs[i])
+				}
+			}
+			if notifier.sent != tt.wantSent {
+				t.Errorf("sent %d notifications, want %d", notifier.sent, tt.wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"order_processing/entity"
+)
+
+// SMTPNotifier mails notifications to {user ID}@{recipient domain}. STARTTLS
+// is used when the server offers it and credentials are only sent when a
+// username is set, so a local fake SMTP server like MailHog works as is.
+type SMTPNotifier struct {
+	addr            string
+	host            string
+	from            string
+	recipientDomain string
+	auth            smtp.Auth
+	timeout         time.Duration
+}
+
+func NewSMTPNotifier(addr, username, password, from, recipientDomain string, timeout time.Duration) *SMTPNotifier {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	sn := &SMTPNotifier{
+		addr:            addr,
+		host:            host,
+		from:            from,
+		recipientDomain: recipientDomain,
+		timeout:         timeout,
+	}
+	if username != "" {
+		sn.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return sn
+}
+
+func (sn *SMTPNotifier) Notify(ctx context.Context, notification *entity.Notification) error {
+	ctx, cancel := context.WithTimeout(ctx, sn.timeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", sn.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// the whole conversation has to finish within the timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, sn.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: sn.host}); err != nil {
+			return err
+		}
+	}
+	if sn.auth != nil {
+		if err := client.Auth(sn.auth); err != nil {
+			return err
+		}
+	}
+
+	to := notification.UserID + "@" + sn.recipientDomain
+	if err := client.Mail(sn.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(sn.message(to, notification)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (sn *SMTPNotifier) message(to string, notification *entity.Notification) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", sn.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", notification.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", notification.EventID, sn.recipientDomain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(notification.Body, "\n", "\r\n"))
+	return msg.Bytes()
+}
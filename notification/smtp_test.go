@@ -0,0 +1,162 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+)
+
+// smtpMail is what the fake server received
+type smtpMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer accepts one conversation and answers RCPT TO with rcptCode,
+// the mail is sent on the returned channel once the client quits
+func fakeSMTPServer(t *testing.T, rcptCode int) (string, <-chan smtpMail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	mails := make(chan smtpMail, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		tp := textproto.NewConn(conn)
+
+		var mail smtpMail
+		tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 fake")
+			case "MAIL":
+				mail.from = arg
+				tp.PrintfLine("250 OK")
+			case "RCPT":
+				if rcptCode != 250 {
+					tp.PrintfLine("%d no such user", rcptCode)
+					continue
+				}
+				mail.to = append(mail.to, arg)
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				mail.data = string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				mails <- mail
+				return
+			default:
+				tp.PrintfLine("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), mails
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, mails := fakeSMTPServer(t, 250)
+	sn := NewSMTPNotifier(addr, "", "", "orders@shop.test", "users.test", 5*time.Second)
+	n := &entity.Notification{
+		EventID: uuid.New(),
+		Type:    "payment.succeeded",
+		UserID:  "u1",
+		Subject: "Your order is paid ✓",
+		Body:    "Hi u1,\nthanks for your order.\n",
+	}
+
+	if err := sn.Notify(context.Background(), n); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+
+	var mail smtpMail
+	select {
+	case mail = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake server got no mail")
+	}
+	if mail.from != "FROM:<orders@shop.test>" {
+		t.Errorf("mail from = %q", mail.from)
+	}
+	if len(mail.to) != 1 || mail.to[0] != "TO:<u1@users.test>" {
+		t.Errorf("rcpt to = %v, want the user at the recipient domain", mail.to)
+	}
+
+	header, body, _ := strings.Cut(mail.data, "\n\n")
+	for _, want := range []string{
+		"To: u1@users.test",
+		"Subject: =?utf-8?q?Your_order_is_paid_=E2=9C=93?=",
+		"Message-ID: <" + n.EventID.String() + "@users.test>",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header doesn't contain %q:\n%s", want, header)
+		}
+	}
+	if body != n.Body {
+		t.Errorf("body = %q, want %q", body, n.Body)
+	}
+}
+
+func TestSMTPNotifierRejectedRecipient(t *testing.T) {
+	addr, _ := fakeSMTPServer(t, 550)
+	sn := NewSMTPNotifier(addr, "", "", "orders@shop.test", "users.test", 5*time.Second)
+
+	err := sn.Notify(context.Background(), &entity.Notification{EventID: uuid.New(), UserID: "nobody"})
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("notify of a rejected recipient = %v, want the 550 answer", err)
+	}
+}
+
+func TestSMTPNotifierTimeout(t *testing.T) {
+	// accepts the connection but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			bufio.NewReader(conn).ReadByte()
+		}
+	}()
+
+	sn := NewSMTPNotifier(ln.Addr().String(), "", "", "orders@shop.test", "users.test", 100*time.Millisecond)
+	start := time.Now()
+	if err := sn.Notify(context.Background(), &entity.Notification{EventID: uuid.New(), UserID: "u1"}); err == nil {
+		t.Fatal("notify through a silent server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("notify took %s, want it to give up after the timeout", elapsed)
+	}
+}
@@ -0,0 +1,57 @@
+package notification
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"order_processing/messaging"
+)
+
+// ErrNoTemplate is returned for events that aren't notified
+var ErrNoTemplate = errors.New("no notification template")
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// every template defines a "subject" and a "body", executed with the event
+// payload, e.g. {{.user_order_id}}
+var templates = mustParseTemplates(
+	messaging.TypeOrderCreated,
+	messaging.TypeStockInsufficient,
+	messaging.TypePaymentSucceeded,
+	messaging.TypePaymentFailed,
+	messaging.TypePaymentDeadLettered,
+)
+
+func mustParseTemplates(msgTypes ...string) map[string]*template.Template {
+	parsed := map[string]*template.Template{}
+	for _, msgType := range msgTypes {
+		parsed[msgType] = template.Must(template.New(msgType).
+			Option("missingkey=error").
+			ParseFS(templateFiles, "templates/"+msgType+".tmpl"))
+	}
+	return parsed
+}
+
+// Render executes the template of an event type with its payload
+func Render(msgType string, payload map[string]any) (subject, body string, err error) {
+	tmpl, ok := templates[msgType]
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrNoTemplate, msgType)
+	}
+
+	var b strings.Builder
+	if err := tmpl.ExecuteTemplate(&b, "subject", payload); err != nil {
+		return "", "", err
+	}
+	subject = strings.TrimSpace(b.String())
+
+	b.Reset()
+	if err := tmpl.ExecuteTemplate(&b, "body", payload); err != nil {
+		return "", "", err
+	}
+	return subject, strings.TrimSpace(b.String()) + "\n", nil
+}
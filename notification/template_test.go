@@ -0,0 +1,40 @@
+package notification
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	payload := map[string]any{
+		"user_order_id": "order-1",
+		"payment_id":    "payment-1",
+		"user_id":       "u1",
+		"product_id":    "product-1",
+		"quantity":      2.0,
+		"attempt":       1.0,
+	}
+
+	subject, body, err := Render("payment.failed", payload)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(subject, "order-1") {
+		t.Errorf("subject %q doesn't name the order", subject)
+	}
+	for _, want := range []string{"Hi u1", "attempt 1", "2 x product-1", "Payment: payment-1"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+
+	delete(payload, "payment_id")
+	if _, _, err := Render("payment.failed", payload); err == nil {
+		t.Error("payload without payment_id rendered")
+	}
+
+	if _, _, err := Render("payment.refund_requested", payload); !errors.Is(err, ErrNoTemplate) {
+		t.Errorf("render of an event without template = %v, want %v", err, ErrNoTemplate)
+	}
+}
@@ -0,0 +1,80 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		delay   time.Duration
+		wantErr string
+	}{
+		{name: "accepted", status: http.StatusOK},
+		{name: "accepted without content", status: http.StatusNoContent},
+		{name: "server error", status: http.StatusBadGateway, wantErr: "webhook answered 502: upstream down"},
+		{name: "rejected", status: http.StatusBadRequest, wantErr: "webhook answered 400"},
+		{name: "timeout", status: http.StatusOK, delay: time.Second, wantErr: "Timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &entity.Notification{
+				EventID:     uuid.New(),
+				Type:        "payment.succeeded",
+				UserID:      "u1",
+				UserOrderID: uuid.NewString(),
+				Subject:     "Your order is paid",
+				Body:        "Hi u1\n",
+			}
+
+			var got entity.Notification
+			var idempotencyKey, contentType string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				idempotencyKey = r.Header.Get("Idempotency-Key")
+				contentType = r.Header.Get("Content-Type")
+				json.NewDecoder(r.Body).Decode(&got)
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+					return
+				}
+				w.WriteHeader(tt.status)
+				if tt.status == http.StatusBadGateway {
+					w.Write([]byte("upstream down\n"))
+				}
+			}))
+			defer server.Close()
+
+			err := NewWebhookNotifier(server.URL, 200*time.Millisecond).Notify(context.Background(), n)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("notify = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("notify: %v", err)
+			}
+			if idempotencyKey != n.EventID.String() {
+				t.Errorf("Idempotency-Key = %q, want the event ID %s", idempotencyKey, n.EventID)
+			}
+			if contentType != "application/json" {
+				t.Errorf("content type = %q", contentType)
+			}
+			if got.EventID != n.EventID || got.UserID != n.UserID || got.Subject != n.Subject || got.Body != n.Body {
+				t.Errorf("posted %+v, want %+v", got, *n)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Topology declares exchanges, queues and bindings, it runs after every
+// (re)connect so a restarted broker gets the topology back
+type Topology func(ch *amqp.Channel) error
+
+// Connection keeps a RabbitMQ connection alive, it reconnects with backoff
+// whenever the broker closes the connection.
+type Connection struct {
+	url      string
+	topology Topology
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	connected chan struct{} // closed while conn is usable
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Dial connects to RabbitMQ, retrying until it succeeds or ctx is done, and
+// applies the topology
+func Dial(ctx context.Context, url string, topology Topology) (*Connection, error) {
+	c := &Connection{
+		url:       url,
+		topology:  topology,
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+	go c.watch()
+	return c, nil
+}
+
+func (c *Connection) connect(ctx context.Context) error {
+	delay := minReconnectDelay
+	for {
+		err := c.dial()
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to connect to RabbitMQ, retrying in %s: %v", delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return ErrNotConnected
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (c *Connection) dial() error {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return err
+	}
+
+	if c.topology != nil {
+		ch, err := conn.Channel()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		err = c.topology(ch)
+		ch.Close()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	close(c.connected)
+	c.mu.Unlock()
+	return nil
+}
+
+// watch reconnects every time the connection is closed by the broker
+func (c *Connection) watch() {
+	for {
+		c.mu.RLock()
+		conn := c.conn
+		c.mu.RUnlock()
+
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		select {
+		case err := <-closed:
+			log.Printf("RabbitMQ connection closed: %v", err)
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		c.connected = make(chan struct{})
+		c.mu.Unlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err := c.connect(ctx)
+		cancel()
+		if err != nil {
+			return
+		}
+		log.Println("Reconnected to RabbitMQ")
+	}
+}
+
+// Channel opens a channel, waiting for the connection to come back if it is
+// down
+func (c *Connection) Channel(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		c.mu.RLock()
+		conn, connected := c.conn, c.connected
+		c.mu.RUnlock()
+
+		select {
+		case <-connected:
+			ch, err := conn.Channel()
+			if !errors.Is(err, amqp.ErrClosed) {
+				return ch, err
+			}
+			// closed between the check and the call, wait for the reconnect
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, ErrNotConnected
+		}
+	}
+}
+
+// channelNow opens a channel without waiting for a reconnect
+func (c *Connection) channelNow() (*amqp.Channel, error) {
+	c.mu.RLock()
+	conn, connected := c.conn, c.connected
+	c.mu.RUnlock()
+
+	select {
+	case <-connected:
+		ch, err := conn.Channel()
+		if errors.Is(err, amqp.ErrClosed) {
+			return nil, ErrNotConnected
+		}
+		return ch, err
+	default:
+		return nil, ErrNotConnected
+	}
+}
+
+// Consume registers a manual ack consumer on queue with its own channel and
+// registers it again after every reconnect. Deliveries received before a
+// reconnect can no longer be acked, the broker redelivers them. The returned
+// channel is closed once ctx is done.
+func (c *Connection) Consume(ctx context.Context, queue string, prefetch int) <-chan amqp.Delivery {
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+		for {
+			ch, err := c.Channel(ctx)
+			if err != nil {
+				return
+			}
+
+			consumerTag := uuid.NewString()
+			msgs, err := c.registerConsumer(ch, queue, consumerTag, prefetch)
+			if err != nil {
+				log.Printf("Failed to register a consumer on %s: %v", queue, err)
+				ch.Close()
+				select {
+				case <-time.After(minReconnectDelay):
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if !forward(ctx, ch, consumerTag, msgs, out) {
+				return
+			}
+			log.Printf("Consumer on %s lost its channel, registering again", queue)
+		}
+	}()
+	return out
+}
+
+// ConsumeWorkers runs workers consumers on queue, each with its own channel
+// and prefetch, and calls handle for every delivery. It returns once ctx is
+// done and every handle call has returned.
+func (c *Connection) ConsumeWorkers(ctx context.Context, queue string, prefetch, workers int, handle func(amqp.Delivery)) {
+	runWorkers(workers, func() <-chan amqp.Delivery {
+		return c.Consume(ctx, queue, prefetch)
+	}, handle)
+}
+
+// runWorkers drains a consumer per worker and returns once all of them are
+// closed and every handle call has returned
+func runWorkers(workers int, consume func() <-chan amqp.Delivery, handle func(amqp.Delivery)) {
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range consume() {
+				handle(d)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *Connection) registerConsumer(ch *amqp.Channel, queue, consumerTag string, prefetch int) (<-chan amqp.Delivery, error) {
+	if prefetch > 0 {
+		if err := ch.Qos(prefetch, 0, false); err != nil {
+			return nil, err
+		}
+	}
+	return ch.Consume(
+		queue,       // queue
+		consumerTag, // consumer
+		false,       // auto ack
+		false,       // exclusive
+		false,       // no local
+		false,       // no wait
+		nil,         // args
+	)
+}
+
+// forward passes deliveries on until the channel dies or ctx is done, it
+// reports false once the consumer should stop for good
+func forward(ctx context.Context, ch *amqp.Channel, consumerTag string, msgs <-chan amqp.Delivery, out chan<- amqp.Delivery) bool {
+	for {
+		select {
+		case d, ok := <-msgs:
+			if !ok {
+				return ctx.Err() == nil
+			}
+			select {
+			case out <- d:
+			case <-ctx.Done():
+				// not handed out, let the broker redeliver it
+				d.Nack(false, true)
+				ch.Cancel(consumerTag, false)
+				return false
+			}
+		case <-ctx.Done():
+			// the channel stays open so in-flight deliveries can still be acked
+			ch.Cancel(consumerTag, false)
+			return false
+		}
+	}
+}
+
+// Close stops reconnecting and closes the connection
+func (c *Connection) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	return conn.Close()
+}
@@ -0,0 +1,55 @@
+package rabbitmq
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// every handler blocks until all of them have started, a worker pool that
+// handles one delivery at a time never gets there
+func TestRunWorkersHandlesInParallel(t *testing.T) {
+	const workers = 4
+
+	// one shared queue, like the broker spreading deliveries over consumers
+	deliveries := make(chan amqp.Delivery, workers)
+	for range workers {
+		deliveries <- amqp.Delivery{}
+	}
+	close(deliveries)
+
+	var started sync.WaitGroup
+	started.Add(workers)
+	release := make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		runWorkers(workers, func() <-chan amqp.Delivery { return deliveries }, func(amqp.Delivery) {
+			started.Done()
+			<-release
+		})
+		close(done)
+	}()
+
+	allStarted := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+
+	select {
+	case <-allStarted:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatalf("%d deliveries weren't handled in parallel", workers)
+	}
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runWorkers didn't return after its consumers were closed")
+	}
+}
@@ -0,0 +1,117 @@
+package rabbitmq
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DLXStore stores messages whose retries ran out, the order repository is one
+type DLXStore interface {
+	InsertDLX(ctx context.Context, dlx *entity.DLX) error
+}
+
+// Retrier handles the failures of a worker consuming SourceQueue. Failed
+// deliveries go to the retry queue bound to Exchange by RoutingKey with one
+// more attempt in their retry headers, once MaxRetries retries are spent they
+// are stored in the dlx table.
+type Retrier struct {
+	Publisher   *ReconnectingPublisher
+	Store       DLXStore
+	Exchange    string
+	RoutingKey  string
+	MaxRetries  int
+	Service     string // service_name of the dlx records
+	SourceQueue string
+}
+
+// requeueDelay holds back a delivery whose retry or dlx record couldn't be
+// written, so an outage of the broker or database doesn't become a tight
+// redelivery loop
+const requeueDelay = 5 * time.Second
+
+// HandleFailure acks the delivery once it is retried or stored and requeues
+// it otherwise. Work aborted by shutdown is requeued without counting the
+// attempt, failures that aren't retryable are stored right away.
+func (r *Retrier) HandleFailure(ctx context.Context, d amqp.Delivery, userOrderID string, retryable bool, err error) {
+	if ctx.Err() != nil {
+		log.Printf("shutting down, requeueing %s message of user order %s", r.Service, userOrderID)
+		d.Nack(false, true)
+		return
+	}
+
+	state := ReadRetryState(d.Headers).Failed(err)
+	retries := state.Attempt - 1
+	if retryable && retries < r.MaxRetries {
+		log.Printf("%s message of user order %s failed (retry %d/%d): %v", r.Service, userOrderID, retries+1, r.MaxRetries, err)
+		if err := Republish(ctx, r.Publisher, d, r.Exchange, r.RoutingKey, state, ""); err != nil {
+			// requeued as it is, the attempt isn't counted
+			log.Printf("unable to retry %s message of user order %s, requeueing in %s: %v", r.Service, userOrderID, requeueDelay, err)
+			Requeue(ctx, d)
+			return
+		}
+		d.Ack(false)
+		return
+	}
+
+	log.Printf("giving up on %s message of user order %s after %d retries: %v", r.Service, userOrderID, retries, err)
+	dlx := &entity.DLX{
+		ID:              uuid.Must(uuid.NewV7()),
+		NumberOfRetries: retries,
+		IsReplayed:      false,
+		ServiceName:     r.Service,
+		Error:           err.Error(),
+		CreatedAt:       time.Now(),
+		Payload:         d.Body,
+		Headers:         d.Headers,
+		RoutingKey:      d.RoutingKey,
+		SourceQueue:     r.SourceQueue,
+		History:         state.History,
+	}
+	if userOrderID != "" {
+		dlx.UserOrderID = &userOrderID
+	}
+	if err := r.Store.InsertDLX(ctx, dlx); err != nil {
+		log.Printf("unable to store dlx record for %s message of user order %s, requeueing in %s: %v", r.Service, userOrderID, requeueDelay, err)
+		Requeue(ctx, d)
+		return
+	}
+	d.Ack(false)
+}
+
+// Requeue puts the delivery back on its queue after requeueDelay, or right
+// away once ctx is done. The consumer takes no other delivery meanwhile.
+func Requeue(ctx context.Context, d amqp.Delivery) {
+	select {
+	case <-time.After(requeueDelay):
+	case <-ctx.Done():
+	}
+	d.Nack(false, true)
+}
+
+// Retryable reports whether a failure may go away on its own: a publish the
+// broker didn't confirm, or an error transient accepts such as a lost
+// database connection. Workers whose steps are idempotent retry both.
+func Retryable(err error, transient func(error) bool) bool {
+	return IsPublishError(err) || transient(err)
+}
+
+// Republish publishes a copy of the delivery with the retry headers of state,
+// expiration is empty unless the message sets its own delay
+func Republish(ctx context.Context, publisher *ReconnectingPublisher, d amqp.Delivery, exchange, routingKey string, state RetryState, expiration string) error {
+	return publisher.Publish(ctx, exchange, routingKey, amqp.Publishing{
+		Headers:       state.Headers(d.Headers),
+		ContentType:   d.ContentType,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Type:          d.Type,
+		Body:          d.Body,
+		DeliveryMode:  amqp.Persistent,
+		Expiration:    expiration,
+	})
+}
@@ -0,0 +1,34 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryable(t *testing.T) {
+	errTransient := errors.New("connection reset")
+	transient := func(err error) bool { return errors.Is(err, errTransient) }
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "not connected", err: &PublishError{Err: ErrNotConnected}, want: true},
+		{name: "nacked", err: &PublishError{Err: ErrPublishNacked}, want: true},
+		{name: "channel closed", err: &PublishError{Err: amqp.ErrClosed}, want: true},
+		{name: "wrapped publish error", err: fmt.Errorf("publish stock.reserved: %w", &PublishError{Err: ErrPublishUnroutable}), want: true},
+		{name: "transient", err: errTransient, want: true},
+		{name: "terminal", err: errors.New("constraint violation"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Retryable(tt.err, transient); got != tt.want {
+				t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package rabbitmq
+
+import (
+	"context"
+	"log"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Park moves a message that can never be processed to the parking lot queue
+// through the default exchange, unchanged apart from headers telling why and
+// where from. The delivery is acked once parked and requeued otherwise.
+func Park(ctx context.Context, publisher *ReconnectingPublisher, d amqp.Delivery, parkingLot, sourceQueue, reason string) {
+	log.Printf("parking message: %s", reason)
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers["x-parked-reason"] = reason
+	headers["x-original-queue"] = sourceQueue
+
+	// the default exchange routes by queue name
+	err := publisher.Publish(ctx, "", parkingLot, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Type:          d.Type,
+		Body:          d.Body,
+		DeliveryMode:  amqp.Persistent,
+	})
+	if err != nil {
+		log.Printf("unable to park message, requeueing in %s: %v", requeueDelay, err)
+		Requeue(ctx, d)
+		return
+	}
+	d.Ack(false)
+}
@@ -0,0 +1,251 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrPublisherClosed   = errors.New("publisher is closed")
+	ErrPublishNacked     = errors.New("message nacked by broker")
+	ErrPublishUnroutable = errors.New("message returned as unroutable")
+)
+
+// PublishError is every failure of ReconnectingPublisher.Publish, the message
+// may not have reached its queues and is worth publishing again
+type PublishError struct {
+	Err error
+}
+
+func (e *PublishError) Error() string { return e.Err.Error() }
+func (e *PublishError) Unwrap() error { return e.Err }
+
+// IsPublishError reports whether err comes from a publish the broker didn't
+// confirm
+func IsPublishError(err error) bool {
+	var publishErr *PublishError
+	return errors.As(err, &publishErr)
+}
+
+// Publisher publishes mandatory messages on a channel in confirm mode and
+// waits for the broker to ack each one.
+type Publisher struct {
+	mu             sync.Mutex // one message in flight at a time
+	ch             *amqp.Channel
+	confirmTimeout time.Duration
+
+	pendingMu sync.Mutex
+	pending   *pendingPublish
+	closed    chan struct{}
+}
+
+// pendingPublish is the message waiting for its confirm, a return of it
+// arrives before the confirm
+type pendingPublish struct {
+	messageID   string
+	deliveryTag uint64
+	returned    *amqp.Return
+	done        chan amqp.Confirmation
+}
+
+func NewPublisher(ch *amqp.Channel, confirmTimeout time.Duration) (*Publisher, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("enable confirm mode: %w", err)
+	}
+
+	publisher := &Publisher{
+		ch:             ch,
+		confirmTimeout: confirmTimeout,
+		closed:         make(chan struct{}),
+	}
+
+	// unbuffered, the connection hands over basic.return and basic.ack one at
+	// a time in the order the broker sent them
+	returns := ch.NotifyReturn(make(chan amqp.Return))
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation))
+	go publisher.dispatch(returns, confirms)
+
+	return publisher, nil
+}
+
+// dispatch hands returns and confirms to the pending publish, those of
+// publishes that gave up waiting are dropped
+func (p *Publisher) dispatch(returns <-chan amqp.Return, confirms <-chan amqp.Confirmation) {
+	defer close(p.closed)
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				return
+			}
+			p.pendingMu.Lock()
+			if p.pending != nil && p.pending.messageID == r.MessageId {
+				p.pending.returned = &r
+			}
+			p.pendingMu.Unlock()
+		case c, ok := <-confirms:
+			if !ok {
+				return
+			}
+			p.pendingMu.Lock()
+			if p.pending != nil && p.pending.deliveryTag == c.DeliveryTag {
+				p.pending.done <- c
+			}
+			p.pendingMu.Unlock()
+		}
+	}
+}
+
+// Publish sends msg and blocks until it is confirmed, nacked, returned or
+// the confirm timeout expires.
+func (p *Publisher) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	if msg.MessageId == "" {
+		msg.MessageId = uuid.NewString()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.confirmTimeout)
+	defer cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := &pendingPublish{
+		messageID:   msg.MessageId,
+		deliveryTag: p.ch.GetNextPublishSeqNo(),
+		done:        make(chan amqp.Confirmation, 1),
+	}
+	p.pendingMu.Lock()
+	p.pending = pending
+	p.pendingMu.Unlock()
+	defer func() {
+		p.pendingMu.Lock()
+		p.pending = nil
+		p.pendingMu.Unlock()
+	}()
+
+	err := p.ch.PublishWithContext(ctx,
+		exchange,
+		routingKey,
+		true,  // mandatory
+		false, // immediate
+		msg,
+	)
+	if err != nil {
+		return fmt.Errorf("publish to %s: %w", exchange, err)
+	}
+
+	var confirmation amqp.Confirmation
+	select {
+	case confirmation = <-pending.done:
+	case <-p.closed:
+		return fmt.Errorf("wait for confirm from %s: %w", exchange, amqp.ErrClosed)
+	case <-ctx.Done():
+		return fmt.Errorf("wait for confirm from %s: %w", exchange, ctx.Err())
+	}
+
+	// recorded by dispatch before it handed over the confirm
+	p.pendingMu.Lock()
+	r := pending.returned
+	p.pendingMu.Unlock()
+
+	if r != nil {
+		return fmt.Errorf("%w: %s (exchange %s, routing key %s)", ErrPublishUnroutable, r.ReplyText, exchange, routingKey)
+	}
+	if !confirmation.Ack {
+		return fmt.Errorf("%w: exchange %s", ErrPublishNacked, exchange)
+	}
+	return nil
+}
+
+// ReconnectingPublisher is a Publisher that follows a Connection across
+// reconnects. While the connection is down Publish fails fast with
+// ErrNotConnected instead of blocking the caller.
+type ReconnectingPublisher struct {
+	conn           *Connection
+	confirmTimeout time.Duration
+
+	mu        sync.Mutex
+	ch        *amqp.Channel
+	publisher *Publisher
+	closed    bool
+	inFlight  sync.WaitGroup
+}
+
+func (c *Connection) NewPublisher(confirmTimeout time.Duration) *ReconnectingPublisher {
+	return &ReconnectingPublisher{
+		conn:           c,
+		confirmTimeout: confirmTimeout,
+	}
+}
+
+func (rp *ReconnectingPublisher) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	publisher, err := rp.current()
+	if err != nil {
+		return &PublishError{Err: err}
+	}
+	defer rp.inFlight.Done()
+	if err := publisher.Publish(ctx, exchange, routingKey, msg); err != nil {
+		return &PublishError{Err: err}
+	}
+	return nil
+}
+
+// Close refuses new publishes and waits for the in-flight ones to be
+// confirmed before closing the channel
+func (rp *ReconnectingPublisher) Close() error {
+	rp.mu.Lock()
+	rp.closed = true
+	rp.mu.Unlock()
+
+	rp.inFlight.Wait()
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.ch == nil || rp.ch.IsClosed() {
+		return nil
+	}
+	return rp.ch.Close()
+}
+
+// current returns the publisher of the live channel, opening a new channel
+// when the previous one was closed. On success the caller owns one count of
+// inFlight.
+func (rp *ReconnectingPublisher) current() (*Publisher, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.closed {
+		return nil, ErrPublisherClosed
+	}
+	publisher, err := rp.open()
+	if err != nil {
+		return nil, err
+	}
+	rp.inFlight.Add(1)
+	return publisher, nil
+}
+
+func (rp *ReconnectingPublisher) open() (*Publisher, error) {
+	if rp.ch != nil && !rp.ch.IsClosed() {
+		return rp.publisher, nil
+	}
+
+	ch, err := rp.conn.channelNow()
+	if err != nil {
+		return nil, err
+	}
+	publisher, err := NewPublisher(ch, rp.confirmTimeout)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	rp.ch = ch
+	rp.publisher = publisher
+	return publisher, nil
+}
@@ -0,0 +1,24 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestReconnectingPublisherWrapsErrors(t *testing.T) {
+	rp := &ReconnectingPublisher{closed: true}
+
+	err := rp.Publish(context.Background(), "exchange", "key", amqp.Publishing{})
+	if !IsPublishError(err) {
+		t.Fatalf("publish on a closed publisher = %v, want a PublishError", err)
+	}
+	if !errors.Is(err, ErrPublisherClosed) {
+		t.Fatalf("publish on a closed publisher = %v, want it to wrap %v", err, ErrPublisherClosed)
+	}
+	if IsPublishError(errors.New("insert user order")) {
+		t.Fatal("an unrelated error is a PublishError")
+	}
+}
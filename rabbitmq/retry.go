@@ -0,0 +1,118 @@
+package rabbitmq
+
+import (
+	"time"
+
+	"order_processing/entity"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Retry headers carried by the message itself, unlike x-death they survive a
+// manual republish and don't depend on which queues the message died in
+const (
+	HeaderAttempt       = "x-attempt" // failed attempts so far
+	HeaderFirstFailedAt = "x-first-failed-at"
+	HeaderLastError     = "x-last-error"
+	HeaderErrorHistory  = "x-error-history" // every failed attempt, oldest first
+
+	// ID of the dlx record a replayed message comes from, kept across its
+	// retries and copied to the events it leads to
+	HeaderReplayedFrom = "x-replayed-from"
+)
+
+type RetryState struct {
+	Attempt       int
+	FirstFailedAt time.Time // zero until the first failure
+	LastError     string
+	History       []entity.DLXAttempt
+}
+
+// ReadRetryState reads the retry headers, a message without them hasn't
+// failed yet
+func ReadRetryState(headers amqp.Table) RetryState {
+	var state RetryState
+	switch attempt := headers[HeaderAttempt].(type) {
+	case int32:
+		state.Attempt = int(attempt)
+	case int64:
+		state.Attempt = int(attempt)
+	}
+	if firstFailedAt, ok := headers[HeaderFirstFailedAt].(time.Time); ok {
+		state.FirstFailedAt = firstFailedAt
+	}
+	if lastError, ok := headers[HeaderLastError].(string); ok {
+		state.LastError = lastError
+	}
+	history, _ := headers[HeaderErrorHistory].([]any)
+	for _, entry := range history {
+		table, ok := entry.(amqp.Table)
+		if !ok {
+			continue
+		}
+		var attempt entity.DLXAttempt
+		switch n := table["attempt"].(type) {
+		case int32:
+			attempt.Attempt = int(n)
+		case int64:
+			attempt.Attempt = int(n)
+		}
+		attempt.Error, _ = table["error"].(string)
+		attempt.FailedAt, _ = table["failed_at"].(time.Time)
+		state.History = append(state.History, attempt)
+	}
+	return state
+}
+
+// Failed records one more failed attempt
+func (s RetryState) Failed(err error) RetryState {
+	s.Attempt++
+	if s.FirstFailedAt.IsZero() {
+		s.FirstFailedAt = time.Now()
+	}
+	s.LastError = err.Error()
+	// copied, states read from the same headers don't share the slice
+	s.History = append(s.History[:len(s.History):len(s.History)], entity.DLXAttempt{Attempt: s.Attempt, Error: s.LastError, FailedAt: time.Now()})
+	return s
+}
+
+// Headers copies headers and sets the retry headers on the copy
+func (s RetryState) Headers(headers amqp.Table) amqp.Table {
+	out := amqp.Table{}
+	for k, v := range headers {
+		out[k] = v
+	}
+	out[HeaderAttempt] = int64(s.Attempt)
+	if !s.FirstFailedAt.IsZero() {
+		out[HeaderFirstFailedAt] = s.FirstFailedAt.UTC()
+	}
+	if s.LastError != "" {
+		out[HeaderLastError] = s.LastError
+	}
+	if len(s.History) > 0 {
+		history := make([]any, 0, len(s.History))
+		for _, attempt := range s.History {
+			history = append(history, amqp.Table{
+				"attempt":   int64(attempt.Attempt),
+				"error":     attempt.Error,
+				"failed_at": attempt.FailedAt.UTC(),
+			})
+		}
+		out[HeaderErrorHistory] = history
+	}
+	return out
+}
+
+// ResetRetryState copies headers without any retry history, so a replayed
+// message starts over with every retry available
+func ResetRetryState(headers amqp.Table) amqp.Table {
+	out := amqp.Table{}
+	for k, v := range headers {
+		switch k {
+		case HeaderAttempt, HeaderFirstFailedAt, HeaderLastError, HeaderErrorHistory, "x-death", "x-first-death-exchange", "x-first-death-queue", "x-first-death-reason", "x-last-death-exchange", "x-last-death-queue", "x-last-death-reason":
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
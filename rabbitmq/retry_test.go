@@ -0,0 +1,40 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// every failure is kept in the headers, so the dlx record of a given up
+// message lists all of them
+func TestRetryStateKeepsErrorHistory(t *testing.T) {
+	headers := amqp.Table{"x-correlation": "kept"}
+	errs := []error{errors.New("connection refused"), errors.New("deadlock detected"), errors.New("timeout")}
+
+	for _, err := range errs {
+		headers = ReadRetryState(headers).Failed(err).Headers(headers)
+	}
+
+	state := ReadRetryState(headers)
+	if state.Attempt != len(errs) {
+		t.Fatalf("attempt = %d, want %d", state.Attempt, len(errs))
+	}
+	if len(state.History) != len(errs) {
+		t.Fatalf("history = %v, want %d attempts", state.History, len(errs))
+	}
+	for i, attempt := range state.History {
+		if attempt.Attempt != i+1 || attempt.Error != errs[i].Error() || attempt.FailedAt.IsZero() {
+			t.Errorf("history[%d] = %+v, want attempt %d failing with %q", i, attempt, i+1, errs[i])
+		}
+	}
+	if headers["x-correlation"] != "kept" {
+		t.Errorf("other headers were dropped: %v", headers)
+	}
+
+	reset := ResetRetryState(headers)
+	if state := ReadRetryState(reset); state.Attempt != 0 || len(state.History) != 0 {
+		t.Errorf("reset state = %+v, want no failures", state)
+	}
+}
@@ -0,0 +1,36 @@
+package rabbitmq
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Serve runs a worker until SIGINT or SIGTERM. ctx passed to run stops the
+// consumers, workCtx aborts in-flight work once shutdownTimeout passes. Serve
+// returns after run does.
+func Serve(shutdownTimeout time.Duration, run func(ctx, workCtx context.Context)) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	done := make(chan struct{})
+	go func() {
+		run(ctx, workCtx)
+		close(done)
+	}()
+
+	<-ctx.Done()
+	log.Printf(" [*] Shutting down, waiting up to %s for in-flight messages", shutdownTimeout)
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		log.Printf(" [*] Shutdown deadline reached, aborting in-flight work, unacked messages will be redelivered")
+		cancelWork()
+		<-done
+	}
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"order_processing/entity"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A live key is stored already, the request was sent before
+var ErrIdempotencyKeyExists = errors.New("idempotency key already used")
+
+type IdempotencyRepository interface {
+	GetIdempotencyKey(ctx context.Context, key string) (*entity.IdempotencyKey, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+type idempotencyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdempotencyRepository(db *pgxpool.Pool) IdempotencyRepository {
+	return &idempotencyRepository{
+		db: db,
+	}
+}
+
+// Insert the key, taking over an expired one. Fails with
+// ErrIdempotencyKeyExists while the stored key is live.
+func insertIdempotencyKey(ctx context.Context, tx pgx.Tx, key *entity.IdempotencyKey) error {
+	query := `
+        INSERT INTO idempotency_keys (key, request_hash, user_order_id, response_status, response_body, created_at, expires_at) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (key) DO UPDATE
+        SET request_hash = EXCLUDED.request_hash,
+            user_order_id = EXCLUDED.user_order_id,
+            response_status = EXCLUDED.response_status,
+            response_body = EXCLUDED.response_body,
+            created_at = EXCLUDED.created_at,
+            expires_at = EXCLUDED.expires_at
+        WHERE idempotency_keys.expires_at <= now()
+    `
+
+	tag, err := tx.Exec(ctx, query,
+		key.Key,
+		key.RequestHash,
+		key.UserOrderID,
+		key.ResponseStatus,
+		key.ResponseBody,
+		key.CreatedAt,
+		key.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIdempotencyKeyExists
+	}
+	return nil
+}
+
+// Get a live key, an expired one is ErrNotFound
+func (ir *idempotencyRepository) GetIdempotencyKey(ctx context.Context, key string) (*entity.IdempotencyKey, error) {
+	query := `
+        SELECT key, request_hash, user_order_id, response_status, response_body, created_at, expires_at
+        FROM idempotency_keys
+        WHERE key = $1 AND expires_at > now()
+    `
+
+	var idempotencyKey entity.IdempotencyKey
+	err := ir.db.QueryRow(ctx, query, key).Scan(
+		&idempotencyKey.Key,
+		&idempotencyKey.RequestHash,
+		&idempotencyKey.UserOrderID,
+		&idempotencyKey.ResponseStatus,
+		&idempotencyKey.ResponseBody,
+		&idempotencyKey.CreatedAt,
+		&idempotencyKey.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &idempotencyKey, nil
+}
+
+// Delete expired keys and return how many were deleted
+func (ir *idempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := ir.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
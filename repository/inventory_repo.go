@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"order_processing/entity"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type InventoryRepository interface {
+	ReserveStock(ctx context.Context, reservation *entity.StockReservation) (*entity.StockReservation, int, error)
+	ReleaseStock(ctx context.Context, userOrderID string) error
+	ReacquireStock(ctx context.Context, userOrderID string) (*entity.StockReservation, int, error)
+}
+
+type inventoryRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewInventoryRepository(db *pgxpool.Pool) InventoryRepository {
+	return &inventoryRepository{
+		db: db,
+	}
+}
+
+// Reserve the stock of a user order, or return the reservation made for it
+// before. The stock row stays locked until the reservation is stored, so
+// concurrent orders of the same product and location can't oversell it. The
+// returned status is reserved or insufficient, along with the stock that was
+// available.
+func (ir *inventoryRepository) ReserveStock(ctx context.Context, reservation *entity.StockReservation) (*entity.StockReservation, int, error) {
+	tx, err := ir.db.Begin(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// a product not stocked at the location has nothing available
+	var available int
+	err = tx.QueryRow(ctx, `
+        SELECT available
+        FROM stock
+        WHERE product_id = $1 AND location = $2
+        FOR UPDATE
+    `, reservation.ProductID, reservation.Location).Scan(&available)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, err
+	}
+
+	var existing entity.StockReservation
+	err = tx.QueryRow(ctx, `
+        SELECT user_order_id, product_id, location, quantity, status, created_at
+        FROM stock_reservations
+        WHERE user_order_id = $1
+    `, reservation.UserOrderID).Scan(
+		&existing.UserOrderID,
+		&existing.ProductID,
+		&existing.Location,
+		&existing.Quantity,
+		&existing.Status,
+		&existing.CreatedAt,
+	)
+	if err == nil {
+		return &existing, available, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, err
+	}
+
+	stored := *reservation
+	stored.Status = entity.ReservationInsufficient
+	if available >= reservation.Quantity {
+		stored.Status = entity.ReservationReserved
+		_, err = tx.Exec(ctx, `
+            UPDATE stock
+            SET available = available - $3, reserved = reserved + $3, updated_at = now()
+            WHERE product_id = $1 AND location = $2
+        `, reservation.ProductID, reservation.Location, reservation.Quantity)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+        INSERT INTO stock_reservations (user_order_id, product_id, location, quantity, status, created_at) 
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `,
+		stored.UserOrderID,
+		stored.ProductID,
+		stored.Location,
+		stored.Quantity,
+		stored.Status,
+		stored.CreatedAt,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &stored, available, tx.Commit(ctx)
+}
+
+// Release the stock reserved for a user order back to the available stock.
+// Orders without a reserved reservation, released ones included, are a no-op.
+func (ir *inventoryRepository) ReleaseStock(ctx context.Context, userOrderID string) error {
+	query := `
+        WITH released AS (
+            UPDATE stock_reservations
+            SET status = $2
+            WHERE user_order_id::text = $1 AND status = $3
+            RETURNING product_id, location, quantity
+        )
+        UPDATE stock s
+        SET available = s.available + r.quantity, reserved = s.reserved - r.quantity, updated_at = now()
+        FROM released r
+        WHERE s.product_id = r.product_id AND s.location = r.location
+    `
+
+	_, err := ir.db.Exec(ctx, query, userOrderID, entity.ReservationReleased, entity.ReservationReserved)
+	return err
+}
+
+// Reserve the stock of a released reservation again, for a user order that is
+// reopened. The returned status is reserved when the stock is there, the
+// reservation is returned as it is otherwise. ErrNotFound when the user order
+// has no reservation.
+func (ir *inventoryRepository) ReacquireStock(ctx context.Context, userOrderID string) (*entity.StockReservation, int, error) {
+	tx, err := ir.db.Begin(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var reservation entity.StockReservation
+	err = tx.QueryRow(ctx, `
+        SELECT user_order_id, product_id, location, quantity, status, created_at
+        FROM stock_reservations
+        WHERE user_order_id::text = $1
+    `, userOrderID).Scan(
+		&reservation.UserOrderID,
+		&reservation.ProductID,
+		&reservation.Location,
+		&reservation.Quantity,
+		&reservation.Status,
+		&reservation.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the stock row is locked before the reservation, like ReserveStock does
+	var available int
+	err = tx.QueryRow(ctx, `
+        SELECT available
+        FROM stock
+        WHERE product_id = $1 AND location = $2
+        FOR UPDATE
+    `, reservation.ProductID, reservation.Location).Scan(&available)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, err
+	}
+	if reservation.Status != entity.ReservationReleased || available < reservation.Quantity {
+		return &reservation, available, nil
+	}
+
+	tag, err := tx.Exec(ctx, `
+        UPDATE stock_reservations
+        SET status = $2
+        WHERE user_order_id::text = $1 AND status = $3
+    `, userOrderID, entity.ReservationReserved, entity.ReservationReleased)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		// released and reacquired concurrently, the other one holds the stock
+		reservation.Status = entity.ReservationReserved
+		return &reservation, available, nil
+	}
+	_, err = tx.Exec(ctx, `
+        UPDATE stock
+        SET available = available - $3, reserved = reserved + $3, updated_at = now()
+        WHERE product_id = $1 AND location = $2
+    `, reservation.ProductID, reservation.Location, reservation.Quantity)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reservation.Status = entity.ReservationReserved
+	return &reservation, available, tx.Commit(ctx)
+}
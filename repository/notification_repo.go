@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+
+	"order_processing/entity"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type NotificationRepository interface {
+	ClaimNotification(ctx context.Context, notification *entity.Notification) (bool, error)
+	ReleaseNotification(ctx context.Context, eventID uuid.UUID) error
+}
+
+type notificationRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewNotificationRepository(db *pgxpool.Pool) NotificationRepository {
+	return &notificationRepository{
+		db: db,
+	}
+}
+
+// Claim the event for sending, false when it was claimed already. The claim
+// is the record of the sent notification once the send succeeds.
+func (nr *notificationRepository) ClaimNotification(ctx context.Context, notification *entity.Notification) (bool, error) {
+	query := `
+        INSERT INTO notifications (event_id, type, user_id, user_order_id, subject, sent_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (event_id) DO NOTHING
+    `
+
+	tag, err := nr.db.Exec(ctx, query,
+		notification.EventID,
+		notification.Type,
+		notification.UserID,
+		notification.UserOrderID,
+		notification.Subject,
+		notification.SentAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Drop the claim of a notification that couldn't be sent, so a retry can
+// claim it again
+func (nr *notificationRepository) ReleaseNotification(ctx context.Context, eventID uuid.UUID) error {
+	_, err := nr.db.Exec(ctx, `DELETE FROM notifications WHERE event_id = $1`, eventID)
+	return err
+}
@@ -3,24 +3,38 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 
 	"order_processing/entity"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type OrderRepository interface {
 	InsertUserOrder(ctx context.Context, userOrder *entity.UserOrder) error
-	InsertPayment(ctx context.Context, payment *entity.Payment) error
+	InsertUserOrderWithOutbox(ctx context.Context, userOrder *entity.UserOrder, outboxes []*entity.Outbox, idempotencyKey *entity.IdempotencyKey) error
+	UpsertPayment(ctx context.Context, payment *entity.Payment) (*entity.Payment, error)
+	InsertPaymentAttempt(ctx context.Context, attempt *entity.PaymentAttempt) error
+	ListPaymentAttempts(ctx context.Context, paymentID string) ([]entity.PaymentAttempt, error)
 	UpdateStatusUserOrder(ctx context.Context, userOrderID string, status entity.Status) error
 	InsertDLX(ctx context.Context, dlx *entity.DLX) error // NEW
+	GetUserOrderStatus(ctx context.Context, userOrderID string) (*entity.UserOrderStatus, error)
+	ListDLXByUserOrderID(ctx context.Context, userOrderID string) ([]entity.DLX, error)
+	ListDLX(ctx context.Context, filter entity.DLXFilter) ([]entity.DLX, error)
+	ReplayDLX(ctx context.Context, dlxID string, force bool) error
 }
 
+var ErrNotFound = errors.New("record not found")
+
 type orderRepository struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewOrderRepository(db *pgx.Conn) OrderRepository {
+func NewOrderRepository(db *pgxpool.Pool) OrderRepository {
 	return &orderRepository{
 		db: db,
 	}
@@ -30,6 +44,7 @@ func (or *orderRepository) InsertUserOrder(ctx context.Context, userOrder *entit
 	query := `
         INSERT INTO user_orders (id, user_id, product_id, quantity, location, status, created_at) 
         VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (id) DO NOTHING
     `
 
 	_, err := or.db.Exec(ctx, query,
@@ -44,40 +59,375 @@ func (or *orderRepository) InsertUserOrder(ctx context.Context, userOrder *entit
 	return err
 }
 
-func (or *orderRepository) InsertPayment(ctx context.Context, payment *entity.Payment) error {
+// Insert user order, its outbox messages and its optional idempotency key in a
+// single transaction. A live key fails it with ErrIdempotencyKeyExists.
+func (or *orderRepository) InsertUserOrderWithOutbox(ctx context.Context, userOrder *entity.UserOrder, outboxes []*entity.Outbox, idempotencyKey *entity.IdempotencyKey) error {
+	tx, err := or.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// first, a concurrent request with the same key waits here until this one is done
+	if idempotencyKey != nil {
+		if err := insertIdempotencyKey(ctx, tx, idempotencyKey); err != nil {
+			return err
+		}
+	}
+
+	userOrderQuery := `
+        INSERT INTO user_orders (id, user_id, product_id, quantity, location, status, created_at) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+	_, err = tx.Exec(ctx, userOrderQuery,
+		userOrder.ID,
+		userOrder.UserID,
+		userOrder.ProductID,
+		userOrder.Quantity,
+		userOrder.Location,
+		userOrder.Status,
+		userOrder.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, outbox := range outboxes {
+		if err := insertOutbox(ctx, tx, outbox); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Insert payment, or return the existing payment of the same user order
+func (or *orderRepository) UpsertPayment(ctx context.Context, payment *entity.Payment) (*entity.Payment, error) {
 	query := `
         INSERT INTO payments (id, user_order_id, created_at) 
         VALUES ($1, $2, $3)
+        ON CONFLICT (user_order_id) DO UPDATE SET user_order_id = EXCLUDED.user_order_id
+        RETURNING id, user_order_id::text, created_at
     `
 
-	_, err := or.db.Exec(ctx, query,
+	var stored entity.Payment
+	err := or.db.QueryRow(ctx, query,
 		payment.ID,
 		payment.UserOrderID,
 		payment.CreatedAt,
+	).Scan(
+		&stored.ID,
+		&stored.UserOrderID,
+		&stored.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// Redelivery of the same attempt overwrites its outcome
+func (or *orderRepository) InsertPaymentAttempt(ctx context.Context, attempt *entity.PaymentAttempt) error {
+	query := `
+        INSERT INTO payment_attempts (id, payment_id, attempt, status, error, created_at) 
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (payment_id, attempt) DO UPDATE SET status = EXCLUDED.status, error = EXCLUDED.error
+    `
+
+	_, err := or.db.Exec(ctx, query,
+		attempt.ID,
+		attempt.PaymentID,
+		attempt.Attempt,
+		attempt.Status,
+		attempt.Error,
+		attempt.CreatedAt,
 	)
 	return err
 }
 
+// Moves the user order to status if the state machine allows it. Returns
+// ErrNotFound when no user order has the given id and
+// entity.ErrInvalidTransition when the current status can't move to status.
+// Moving to the status the order is already in is a no-op.
 func (or *orderRepository) UpdateStatusUserOrder(ctx context.Context, userOrderID string, status entity.Status) error {
-	_, err := or.db.Exec(ctx, "update user_orders set status=$1 where id=$2", status, userOrderID)
-	return err
+	var allowedBefore []string
+	for _, s := range entity.StatusesAllowedBefore(status) {
+		allowedBefore = append(allowedBefore, string(s))
+	}
+
+	tag, err := or.db.Exec(ctx,
+		"update user_orders set status=$1 where id=$2 and status::text = any($3)",
+		status, userOrderID, allowedBefore,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 1 {
+		return nil
+	}
+
+	var current entity.Status
+	err = or.db.QueryRow(ctx, "select status from user_orders where id=$1", userOrderID).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("update status of user order %s: %w", userOrderID, ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if current == status {
+		return nil
+	}
+	return fmt.Errorf("update status of user order %s from %s to %s: %w", userOrderID, current, status, entity.ErrInvalidTransition)
+}
+
+// List the attempts of a payment, oldest first
+func (or *orderRepository) ListPaymentAttempts(ctx context.Context, paymentID string) ([]entity.PaymentAttempt, error) {
+	query := `
+        SELECT id, payment_id, attempt, status, error, created_at
+        FROM payment_attempts
+        WHERE payment_id::text = $1
+        ORDER BY attempt
+    `
+
+	rows, err := or.db.Query(ctx, query, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []entity.PaymentAttempt{}
+	for rows.Next() {
+		var attempt entity.PaymentAttempt
+		err := rows.Scan(
+			&attempt.ID,
+			&attempt.PaymentID,
+			&attempt.Attempt,
+			&attempt.Status,
+			&attempt.Error,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
 }
 
 // NEW: Insert DLX record
 func (or *orderRepository) InsertDLX(ctx context.Context, dlx *entity.DLX) error {
 	query := `
-        INSERT INTO dlx (id, payment_id, number_of_retries, is_replayed, service_name, error, created_at) 
-        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        INSERT INTO dlx (id, payment_id, user_order_id, number_of_retries, is_replayed, service_name, error, created_at,
+            payload, headers, routing_key, source_queue, history) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
     `
 
 	_, err := or.db.Exec(ctx, query,
 		dlx.ID,
 		dlx.PaymentID,
+		dlx.UserOrderID,
 		dlx.NumberOfRetries,
 		dlx.IsReplayed,
 		dlx.ServiceName,
 		dlx.Error,
 		dlx.CreatedAt,
+		dlxPayload(dlx.Payload),
+		dlx.Headers,
+		dlx.RoutingKey,
+		dlx.SourceQueue,
+		dlx.History,
 	)
 	return err
 }
+
+// payload is stored as JSONB, a body that isn't JSON is kept as a JSON string
+func dlxPayload(payload json.RawMessage) any {
+	if payload == nil {
+		return nil
+	}
+	if json.Valid(payload) {
+		return payload
+	}
+	quoted, _ := json.Marshal(string(payload))
+	return json.RawMessage(quoted)
+}
+
+// Get user order joined with its payment
+func (or *orderRepository) GetUserOrderStatus(ctx context.Context, userOrderID string) (*entity.UserOrderStatus, error) {
+	query := `
+        SELECT uo.id, uo.user_id, uo.product_id, uo.quantity, uo.location, uo.status, uo.created_at, p.id::text
+        FROM user_orders uo
+        LEFT JOIN payments p ON p.user_order_id::text = uo.id::text
+        WHERE uo.id = $1
+        ORDER BY p.created_at DESC
+        LIMIT 1
+    `
+
+	var status entity.UserOrderStatus
+	err := or.db.QueryRow(ctx, query, userOrderID).Scan(
+		&status.ID,
+		&status.UserID,
+		&status.ProductID,
+		&status.Quantity,
+		&status.Location,
+		&status.Status,
+		&status.CreatedAt,
+		&status.PaymentID,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// dlx columns read by scanDLX, records stored before user_order_id was
+// tracked get it through their payment
+const dlxColumns = `d.id, d.payment_id::text, COALESCE(d.user_order_id::text, p.user_order_id::text), d.number_of_retries,
+        d.is_replayed, d.replayed_at, d.service_name, d.error, d.created_at,
+        d.payload, d.headers, COALESCE(d.routing_key, ''), COALESCE(d.source_queue, ''), d.history`
+
+// List DLX records of a user order and of its payments
+func (or *orderRepository) ListDLXByUserOrderID(ctx context.Context, userOrderID string) ([]entity.DLX, error) {
+	query := `
+        SELECT ` + dlxColumns + `
+        FROM dlx d
+        LEFT JOIN payments p ON p.id::text = d.payment_id::text
+        WHERE d.user_order_id::text = $1 OR p.user_order_id::text = $1
+        ORDER BY d.created_at
+    `
+
+	rows, err := or.db.Query(ctx, query, userOrderID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDLX(rows)
+}
+
+// ErrorContains is matched as plain text, not as a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// List DLX records matching the filter, oldest first
+func (or *orderRepository) ListDLX(ctx context.Context, filter entity.DLXFilter) ([]entity.DLX, error) {
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.ID != "" {
+		where("d.id::text = $%d", filter.ID)
+	}
+	if filter.ServiceName != "" {
+		where("d.service_name = $%d", filter.ServiceName)
+	}
+	if filter.ErrorContains != "" {
+		where(`d.error ILIKE '%%' || $%d || '%%'`, likeEscaper.Replace(filter.ErrorContains))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		where("d.created_at >= $%d", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		where("d.created_at < $%d", filter.CreatedTo)
+	}
+	if !filter.IncludeReplayed {
+		conditions = append(conditions, "NOT d.is_replayed")
+	}
+
+	query := `
+        SELECT ` + dlxColumns + `
+        FROM dlx d
+        LEFT JOIN payments p ON p.id::text = d.payment_id::text
+    `
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	query += "ORDER BY d.created_at\n"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf("LIMIT $%d\n", len(args))
+	}
+
+	rows, err := or.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanDLX(rows)
+}
+
+func scanDLX(rows pgx.Rows) ([]entity.DLX, error) {
+	defer rows.Close()
+
+	dlxs := []entity.DLX{}
+	for rows.Next() {
+		var dlx entity.DLX
+		err := rows.Scan(
+			&dlx.ID,
+			&dlx.PaymentID,
+			&dlx.UserOrderID,
+			&dlx.NumberOfRetries,
+			&dlx.IsReplayed,
+			&dlx.ReplayedAt,
+			&dlx.ServiceName,
+			&dlx.Error,
+			&dlx.CreatedAt,
+			&dlx.Payload,
+			&dlx.Headers,
+			&dlx.RoutingKey,
+			&dlx.SourceQueue,
+			&dlx.History,
+		)
+		if err != nil {
+			return nil, err
+		}
+		dlxs = append(dlxs, dlx)
+	}
+	return dlxs, rows.Err()
+}
+
+// Mark a DLX record replayed and reopen the cancelled user order of a
+// payment record so the payment can run again. A record that is already
+// replayed is ErrNotFound unless force is set, a payment record whose order
+// is neither cancelled nor payment failed is entity.ErrInvalidTransition.
+func (or *orderRepository) ReplayDLX(ctx context.Context, dlxID string, force bool) error {
+	tx, err := or.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userOrderID *string
+	var serviceName string
+	err = tx.QueryRow(ctx, `
+        UPDATE dlx d
+        SET is_replayed = true, replayed_at = now()
+        WHERE d.id = $1 AND (NOT d.is_replayed OR $2)
+        RETURNING COALESCE(d.user_order_id::text, (SELECT p.user_order_id::text FROM payments p WHERE p.id::text = d.payment_id::text)), d.service_name
+    `, dlxID, force).Scan(&userOrderID, &serviceName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// replay overrides the cancellation done when the payment was given up,
+	// the state machine has no way out of cancelled for anything else
+	if serviceName == "payment" && userOrderID != nil {
+		tag, err := tx.Exec(ctx, `
+            UPDATE user_orders
+            SET status = $2
+            WHERE id::text = $1 AND status::text IN ($2, $3)
+        `, *userOrderID, entity.StatusPaymentFailed, entity.StatusCancelled)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("reopen user order %s: %w", *userOrderID, entity.ErrInvalidTransition)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+
+	"order_processing/entity"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OutboxRepository interface {
+	ClaimPendingOutbox(ctx context.Context, limit int) (OutboxBatch, error)
+}
+
+// OutboxBatch holds the row locks of claimed outbox rows until it is
+// committed or rolled back, other relays skip them meanwhile
+type OutboxBatch interface {
+	Outboxes() []entity.Outbox
+	MarkSent(ctx context.Context, outboxID string) error
+	// MarkFailed counts a failed publish, the row is dead once it has failed
+	// maxAttempts times
+	MarkFailed(ctx context.Context, outboxID string, publishErr error, maxAttempts int) (dead bool, err error)
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+type outboxRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewOutboxRepository(db *pgxpool.Pool) OutboxRepository {
+	return &outboxRepository{
+		db: db,
+	}
+}
+
+type outboxBatch struct {
+	tx       pgx.Tx
+	outboxes []entity.Outbox
+}
+
+func insertOutbox(ctx context.Context, tx pgx.Tx, outbox *entity.Outbox) error {
+	query := `
+        INSERT INTO outbox (id, exchange, routing_key, payload, created_at) 
+        VALUES ($1, $2, $3, $4, $5)
+    `
+
+	_, err := tx.Exec(ctx, query,
+		outbox.ID,
+		outbox.Exchange,
+		outbox.RoutingKey,
+		outbox.Payload,
+		outbox.CreatedAt,
+	)
+	return err
+}
+
+// Claim up to limit rows that are neither published nor dead, oldest first.
+// Rows claimed by another relay are skipped, so relays running side by side
+// publish each row once but only keep the order within their own batch.
+func (obr *outboxRepository) ClaimPendingOutbox(ctx context.Context, limit int) (OutboxBatch, error) {
+	query := `
+        SELECT id, exchange, routing_key, payload, attempts, last_error, created_at, sent_at, dead_at
+        FROM outbox
+        WHERE sent_at IS NULL AND dead_at IS NULL
+        ORDER BY created_at
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    `
+
+	tx, err := obr.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	outboxes, err := scanOutbox(ctx, tx, query, limit)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return &outboxBatch{tx: tx, outboxes: outboxes}, nil
+}
+
+func scanOutbox(ctx context.Context, tx pgx.Tx, query string, args ...any) ([]entity.Outbox, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	outboxes := []entity.Outbox{}
+	for rows.Next() {
+		var outbox entity.Outbox
+		err := rows.Scan(
+			&outbox.ID,
+			&outbox.Exchange,
+			&outbox.RoutingKey,
+			&outbox.Payload,
+			&outbox.Attempts,
+			&outbox.LastError,
+			&outbox.CreatedAt,
+			&outbox.SentAt,
+			&outbox.DeadAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		outboxes = append(outboxes, outbox)
+	}
+	return outboxes, rows.Err()
+}
+
+func (ob *outboxBatch) Outboxes() []entity.Outbox {
+	return ob.outboxes
+}
+
+func (ob *outboxBatch) MarkSent(ctx context.Context, outboxID string) error {
+	_, err := ob.tx.Exec(ctx, "update outbox set sent_at=now(), attempts=attempts+1, last_error=null where id=$1", outboxID)
+	return err
+}
+
+func (ob *outboxBatch) MarkFailed(ctx context.Context, outboxID string, publishErr error, maxAttempts int) (bool, error) {
+	query := `
+        UPDATE outbox
+        SET attempts = attempts + 1,
+            last_error = $1,
+            dead_at = CASE WHEN attempts + 1 >= $2 THEN now() END
+        WHERE id = $3
+        RETURNING dead_at IS NOT NULL
+    `
+
+	var dead bool
+	err := ob.tx.QueryRow(ctx, query, publishErr.Error(), maxAttempts, outboxID).Scan(&dead)
+	return dead, err
+}
+
+func (ob *outboxBatch) Commit(ctx context.Context) error {
+	return ob.tx.Commit(ctx)
+}
+
+func (ob *outboxBatch) Rollback(ctx context.Context) error {
+	return ob.tx.Rollback(ctx)
+}
@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"order_processing/entity"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ProductRepository interface {
+	CreateProduct(ctx context.Context, product *entity.Product) error
+	GetProduct(ctx context.Context, id string) (*entity.Product, error)
+	ListProducts(ctx context.Context, filter entity.ProductFilter) ([]entity.Product, error)
+	UpdateProduct(ctx context.Context, product *entity.Product) error
+	ArchiveProduct(ctx context.Context, id string) error
+}
+
+// StockBelowReservedError is returned when a location is given less stock
+// than is reserved there
+type StockBelowReservedError struct {
+	Location string
+	Reserved int
+}
+
+func (e *StockBelowReservedError) Error() string {
+	return fmt.Sprintf("stock at %s can't be below the %d reserved", e.Location, e.Reserved)
+}
+
+type productRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewProductRepository(db *pgxpool.Pool) ProductRepository {
+	return &productRepository{
+		db: db,
+	}
+}
+
+const productColumns = `id, name, description, price, archived_at, created_at, updated_at`
+
+func scanProduct(row pgx.Row) (*entity.Product, error) {
+	var product entity.Product
+	err := row.Scan(
+		&product.ID,
+		&product.Name,
+		&product.Description,
+		&product.Price,
+		&product.ArchivedAt,
+		&product.CreatedAt,
+		&product.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// Insert product along with its stock
+func (pr *productRepository) CreateProduct(ctx context.Context, product *entity.Product) error {
+	tx, err := pr.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+        INSERT INTO products (id, name, description, price, archived_at, created_at, updated_at) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+	_, err = tx.Exec(ctx, query,
+		product.ID,
+		product.Name,
+		product.Description,
+		product.Price,
+		product.ArchivedAt,
+		product.CreatedAt,
+		product.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := upsertStock(ctx, tx, product); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Get product with its stock, archived products included
+func (pr *productRepository) GetProduct(ctx context.Context, id string) (*entity.Product, error) {
+	query := `SELECT ` + productColumns + ` FROM products WHERE id::text = $1`
+	product, err := scanProduct(pr.db.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	products := []entity.Product{*product}
+	if err := pr.loadStock(ctx, products); err != nil {
+		return nil, err
+	}
+	return &products[0], nil
+}
+
+// List products after filter.After by ID, with their stock
+func (pr *productRepository) ListProducts(ctx context.Context, filter entity.ProductFilter) ([]entity.Product, error) {
+	query := `
+        SELECT ` + productColumns + `
+        FROM products
+        WHERE ($1::uuid IS NULL OR id > $1) AND ($2 OR archived_at IS NULL)
+        ORDER BY id
+        LIMIT $3
+    `
+
+	rows, err := pr.db.Query(ctx, query, filter.After, filter.IncludeArchived, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []entity.Product{}
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, *product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := pr.loadStock(ctx, products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// Replace name, description and price of a product and set the stock of the
+// given locations. Archived products can still be updated. Returns
+// ErrNotFound when no product has the ID and StockBelowReservedError when a
+// location would have less stock than is reserved.
+func (pr *productRepository) UpdateProduct(ctx context.Context, product *entity.Product) error {
+	tx, err := pr.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+        UPDATE products
+        SET name = $2, description = $3, price = $4, updated_at = $5
+        WHERE id = $1
+        RETURNING archived_at, created_at
+    `
+	err = tx.QueryRow(ctx, query,
+		product.ID,
+		product.Name,
+		product.Description,
+		product.Price,
+		product.UpdatedAt,
+	).Scan(&product.ArchivedAt, &product.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := upsertStock(ctx, tx, product); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Archive a product, archiving it again keeps the first archived_at. Returns
+// ErrNotFound when no product has the ID.
+func (pr *productRepository) ArchiveProduct(ctx context.Context, id string) error {
+	query := `
+        UPDATE products
+        SET archived_at = COALESCE(archived_at, now()), updated_at = now()
+        WHERE id::text = $1
+    `
+
+	tag, err := pr.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Set the stock of every location listed on the product. The stock given is
+// what is on hand, reserved units included, so the reservations of
+// inventory-worker are kept. Returns StockBelowReservedError when a location
+// is given less than is reserved there.
+func upsertStock(ctx context.Context, tx pgx.Tx, product *entity.Product) error {
+	query := `
+        INSERT INTO stock (product_id, location, available, updated_at) 
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (product_id, location) DO UPDATE
+        SET available = EXCLUDED.available - stock.reserved, updated_at = EXCLUDED.updated_at
+        WHERE stock.reserved <= EXCLUDED.available
+    `
+
+	for _, stock := range product.Stock {
+		tag, err := tx.Exec(ctx, query, product.ID.String(), stock.Location, stock.Available, product.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			var reserved int
+			err := tx.QueryRow(ctx, `SELECT reserved FROM stock WHERE product_id = $1 AND location = $2`, product.ID.String(), stock.Location).Scan(&reserved)
+			if err != nil {
+				return err
+			}
+			return &StockBelowReservedError{Location: stock.Location, Reserved: reserved}
+		}
+	}
+	return nil
+}
+
+// loadStock replaces the stock of the products with their stock rows
+func (pr *productRepository) loadStock(ctx context.Context, products []entity.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]string, len(products))
+	byID := make(map[string]*entity.Product, len(products))
+	for i := range products {
+		ids[i] = products[i].ID.String()
+		products[i].Stock = []entity.Stock{}
+		byID[ids[i]] = &products[i]
+	}
+
+	query := `
+        SELECT product_id, location, available, reserved, updated_at
+        FROM stock
+        WHERE product_id = ANY($1)
+        ORDER BY location
+    `
+
+	rows, err := pr.db.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stock entity.Stock
+		err := rows.Scan(
+			&stock.ProductID,
+			&stock.Location,
+			&stock.Available,
+			&stock.Reserved,
+			&stock.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		byID[stock.ProductID].Stock = append(byID[stock.ProductID].Stock, stock)
+	}
+	return rows.Err()
+}
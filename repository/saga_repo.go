@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"order_processing/entity"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The saga was updated since it was read, read it again and retry
+var ErrSagaChanged = errors.New("saga changed concurrently")
+
+type SagaRepository interface {
+	UpsertSaga(ctx context.Context, saga *entity.Saga) (*entity.Saga, error)
+	GetSaga(ctx context.Context, userOrderID string) (*entity.Saga, error)
+	UpdateSaga(ctx context.Context, saga *entity.Saga) error
+	ListExpiredSagas(ctx context.Context, now time.Time, limit int) ([]entity.Saga, error)
+}
+
+type sagaRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSagaRepository(db *pgxpool.Pool) SagaRepository {
+	return &sagaRepository{
+		db: db,
+	}
+}
+
+const sagaColumns = `user_order_id, state, stock_reserved, payment_id, error, deadline, version, created_at, updated_at`
+
+func scanSaga(row pgx.Row) (*entity.Saga, error) {
+	var saga entity.Saga
+	err := row.Scan(
+		&saga.UserOrderID,
+		&saga.State,
+		&saga.StockReserved,
+		&saga.PaymentID,
+		&saga.Error,
+		&saga.Deadline,
+		&saga.Version,
+		&saga.CreatedAt,
+		&saga.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &saga, nil
+}
+
+// Insert saga, or return the existing saga of the same user order
+func (sr *sagaRepository) UpsertSaga(ctx context.Context, saga *entity.Saga) (*entity.Saga, error) {
+	query := `
+        INSERT INTO sagas (user_order_id, state, stock_reserved, payment_id, error, deadline, version, created_at, updated_at) 
+        VALUES ($1, $2, $3, $4, $5, $6, 0, $7, $7)
+        ON CONFLICT (user_order_id) DO UPDATE SET user_order_id = EXCLUDED.user_order_id
+        RETURNING ` + sagaColumns
+
+	return scanSaga(sr.db.QueryRow(ctx, query,
+		saga.UserOrderID,
+		saga.State,
+		saga.StockReserved,
+		saga.PaymentID,
+		saga.Error,
+		saga.Deadline,
+		saga.CreatedAt,
+	))
+}
+
+func (sr *sagaRepository) GetSaga(ctx context.Context, userOrderID string) (*entity.Saga, error) {
+	query := `SELECT ` + sagaColumns + ` FROM sagas WHERE user_order_id::text = $1`
+	return scanSaga(sr.db.QueryRow(ctx, query, userOrderID))
+}
+
+// Store the saga if nobody updated it since it was read, the version it was
+// read with is bumped. Returns ErrSagaChanged otherwise.
+func (sr *sagaRepository) UpdateSaga(ctx context.Context, saga *entity.Saga) error {
+	query := `
+        UPDATE sagas
+        SET state = $3, stock_reserved = $4, payment_id = $5, error = $6, deadline = $7,
+            version = version + 1, updated_at = now()
+        WHERE user_order_id = $1 AND version = $2
+        RETURNING version, updated_at
+    `
+
+	err := sr.db.QueryRow(ctx, query,
+		saga.UserOrderID,
+		saga.Version,
+		saga.State,
+		saga.StockReserved,
+		saga.PaymentID,
+		saga.Error,
+		saga.Deadline,
+	).Scan(&saga.Version, &saga.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrSagaChanged
+	}
+	return err
+}
+
+// List unfinished sagas past their deadline, oldest deadline first
+func (sr *sagaRepository) ListExpiredSagas(ctx context.Context, now time.Time, limit int) ([]entity.Saga, error) {
+	query := `
+        SELECT ` + sagaColumns + `
+        FROM sagas
+        WHERE state NOT IN ($1, $2) AND deadline <= $3
+        ORDER BY deadline
+        LIMIT $4
+    `
+
+	rows, err := sr.db.Query(ctx, query, entity.SagaCompleted, entity.SagaCancelled, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sagas := []entity.Saga{}
+	for rows.Next() {
+		saga, err := scanSaga(rows)
+		if err != nil {
+			return nil, err
+		}
+		sagas = append(sagas, *saga)
+	}
+	return sagas, rows.Err()
+}
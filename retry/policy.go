@@ -0,0 +1,53 @@
+// Package retry defines the backoff ladder of failed messages. Every delay of
+// a policy gets its own TTL queue, so a retry only waits behind messages of
+// the same delay.
+package retry
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+type Policy struct {
+	Delays []time.Duration // delay before retry n, the last one repeats
+	Jitter float64         // e.g. 0.1 spreads a delay by +-10%
+}
+
+// PaymentPolicy spaces gateway retries out so an outage isn't hammered
+var PaymentPolicy = Policy{
+	Delays: []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute, 10 * time.Minute},
+	Jitter: 0.1,
+}
+
+// Tier picks the delay of the retry following retryCount earlier retries
+func (p Policy) Tier(retryCount int) int {
+	return min(max(retryCount, 0), len(p.Delays)-1)
+}
+
+// Delay is the jittered delay of a tier, used as the per-message expiration
+func (p Policy) Delay(tier int) time.Duration {
+	delay := float64(p.Delays[tier])
+	return time.Duration(delay + delay*p.Jitter*(2*rand.Float64()-1))
+}
+
+// MaxDelay is the queue TTL of a tier, a message never waits longer
+func (p Policy) MaxDelay(tier int) time.Duration {
+	delay := float64(p.Delays[tier])
+	return time.Duration(delay + delay*p.Jitter)
+}
+
+// QueueName names the queue of a tier after its delay, e.g. retry_queue_30s
+func (p Policy) QueueName(prefix string, tier int) string {
+	delay := p.Delays[tier]
+	if delay%time.Minute == 0 {
+		return fmt.Sprintf("%s_%dm", prefix, delay/time.Minute)
+	}
+	return fmt.Sprintf("%s_%ds", prefix, delay/time.Second)
+}
+
+// Expiration formats a delay as an AMQP per-message expiration
+func Expiration(delay time.Duration) string {
+	return strconv.FormatInt(delay.Milliseconds(), 10)
+}
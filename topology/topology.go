@@ -0,0 +1,215 @@
+// Package topology defines every exchange, queue and binding of the order
+// processing system in one place. Every service applies the same definition
+// so the arguments of a queue can't drift between services, which RabbitMQ
+// would reject with PRECONDITION_FAILED.
+package topology
+
+import (
+	"fmt"
+
+	"order_processing/config"
+	"order_processing/constants"
+	"order_processing/retry"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type Exchange struct {
+	Name string
+	Kind string
+}
+
+type Queue struct {
+	Name string
+	Args amqp.Table
+}
+
+type Binding struct {
+	Queue      string
+	Exchange   string
+	RoutingKey string
+}
+
+// Topology is declared durable, nothing is auto-deleted or exclusive
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+	Bindings  []Binding
+}
+
+func New(cfg *config.Config) Topology {
+	t := Topology{
+		Exchanges: []Exchange{
+			{Name: cfg.Exchanges.UserOrderDirect, Kind: amqp.ExchangeDirect},
+			{Name: cfg.Exchanges.PaymentDirect, Kind: amqp.ExchangeDirect},
+			{Name: cfg.Exchanges.OrderEvents, Kind: amqp.ExchangeTopic},
+			// order.created is fanned out to every service reacting to new orders
+			{Name: cfg.Exchanges.StockBroadcast, Kind: amqp.ExchangeFanout},
+			{Name: cfg.Exchanges.DLX, Kind: amqp.ExchangeDirect},
+		},
+		Queues: []Queue{
+			{
+				// failed user orders are republished to their retry queue through the DLX,
+				// the dead letter arguments predate that and stay so the queue declares the same
+				Name: cfg.Queues.UserOrder,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    cfg.Exchanges.DLX,
+					"x-dead-letter-routing-key": constants.RoutingKeyUserOrderRetry,
+				},
+			},
+			{
+				Name: cfg.Queues.UserOrderRetry,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    cfg.Exchanges.UserOrderDirect,
+					"x-dead-letter-routing-key": constants.RoutingKeyUserOrder,
+					"x-message-ttl":             cfg.Retry.RetryDelaySeconds * 1000,
+				},
+			},
+			{
+				// rejected payments are dead lettered to the first retry tier
+				Name: cfg.Queues.Payment,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    cfg.Exchanges.DLX,
+					"x-dead-letter-routing-key": constants.RoutingKeyRetry,
+				},
+			},
+			{
+				// inventory failing on transient errors is republished to its retry queue
+				Name: cfg.Queues.Inventory,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    cfg.Exchanges.DLX,
+					"x-dead-letter-routing-key": constants.RoutingKeyInventoryRetry,
+				},
+			},
+			{
+				// back through the default exchange, the fanout would hand it to every queue again
+				Name: cfg.Queues.InventoryRetry,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": cfg.Queues.Inventory,
+					"x-message-ttl":             cfg.Retry.RetryDelaySeconds * 1000,
+				},
+			},
+			{
+				// notifications failing to send are republished to their retry queue
+				Name: cfg.Queues.Notification,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    cfg.Exchanges.DLX,
+					"x-dead-letter-routing-key": constants.RoutingKeyNotificationRetry,
+				},
+			},
+			{
+				Name: cfg.Queues.NotificationRetry,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": cfg.Queues.Notification,
+					"x-message-ttl":             cfg.Retry.RetryDelaySeconds * 1000,
+				},
+			},
+			{
+				// saga events failing on transient errors are republished to their retry queue
+				Name: cfg.Queues.Saga,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    cfg.Exchanges.DLX,
+					"x-dead-letter-routing-key": constants.RoutingKeySagaRetry,
+				},
+			},
+			{
+				Name: cfg.Queues.SagaRetry,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": cfg.Queues.Saga,
+					"x-message-ttl":             cfg.Retry.RetryDelaySeconds * 1000,
+				},
+			},
+			// refunds requested by the saga, failed refunds are republished to their retry queue
+			{Name: cfg.Queues.PaymentRefund},
+			{
+				Name: cfg.Queues.PaymentRefundRetry,
+				Args: amqp.Table{
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": cfg.Queues.PaymentRefund,
+					"x-message-ttl":             cfg.Retry.RetryDelaySeconds * 1000,
+				},
+			},
+			// malformed messages are parked here through the default exchange for inspection
+			{Name: cfg.Queues.ParkingLot},
+		},
+		Bindings: []Binding{
+			{Queue: cfg.Queues.UserOrder, Exchange: cfg.Exchanges.UserOrderDirect, RoutingKey: constants.RoutingKeyUserOrder},
+			{Queue: cfg.Queues.Payment, Exchange: cfg.Exchanges.PaymentDirect, RoutingKey: constants.RoutingKeyPayment},
+			// payment starts once inventory-worker has reserved the stock
+			{Queue: cfg.Queues.Payment, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyStockReserved},
+			{Queue: cfg.Queues.Inventory, Exchange: cfg.Exchanges.StockBroadcast},
+			{Queue: cfg.Queues.InventoryRetry, Exchange: cfg.Exchanges.DLX, RoutingKey: constants.RoutingKeyInventoryRetry},
+			{Queue: cfg.Queues.UserOrderRetry, Exchange: cfg.Exchanges.DLX, RoutingKey: constants.RoutingKeyUserOrderRetry},
+			// users are told about new orders and how they end
+			{Queue: cfg.Queues.Notification, Exchange: cfg.Exchanges.StockBroadcast},
+			{Queue: cfg.Queues.Notification, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyStockInsufficient},
+			{Queue: cfg.Queues.Notification, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyPaymentSucceeded},
+			{Queue: cfg.Queues.Notification, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyPaymentFailed},
+			{Queue: cfg.Queues.Notification, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyPaymentDeadLettered},
+			{Queue: cfg.Queues.NotificationRetry, Exchange: cfg.Exchanges.DLX, RoutingKey: constants.RoutingKeyNotificationRetry},
+			// the saga of an order advances on the replies of every step
+			{Queue: cfg.Queues.Saga, Exchange: cfg.Exchanges.StockBroadcast},
+			{Queue: cfg.Queues.Saga, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyStockReserved},
+			{Queue: cfg.Queues.Saga, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyStockInsufficient},
+			{Queue: cfg.Queues.Saga, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyPaymentSucceeded},
+			{Queue: cfg.Queues.Saga, Exchange: cfg.Exchanges.OrderEvents, RoutingKey: constants.RoutingKeyPaymentDeadLettered},
+			{Queue: cfg.Queues.SagaRetry, Exchange: cfg.Exchanges.DLX, RoutingKey: constants.RoutingKeySagaRetry},
+			// refunds go through payment-worker, which holds the gateway state
+			{Queue: cfg.Queues.PaymentRefund, Exchange: cfg.Exchanges.PaymentDirect, RoutingKey: constants.RoutingKeyPaymentRefund},
+			{Queue: cfg.Queues.PaymentRefundRetry, Exchange: cfg.Exchanges.DLX, RoutingKey: constants.RoutingKeyPaymentRefundRetry},
+		},
+	}
+	t.addRetryLadder(cfg, retry.PaymentPolicy)
+	return t
+}
+
+// addRetryLadder adds a TTL queue per delay of the payment retry policy, bound
+// to the DLX by its own name. Expired payments go back to the payment queue.
+// Rejected payments are dead lettered to the first tier.
+func (t *Topology) addRetryLadder(cfg *config.Config, policy retry.Policy) {
+	for tier := range policy.Delays {
+		name := policy.QueueName(cfg.Queues.Retry, tier)
+		t.Queues = append(t.Queues, Queue{
+			Name: name,
+			Args: amqp.Table{
+				"x-dead-letter-exchange":    cfg.Exchanges.PaymentDirect,
+				"x-dead-letter-routing-key": constants.RoutingKeyPayment,
+				"x-message-ttl":             int(policy.MaxDelay(tier).Milliseconds()),
+			},
+		})
+		t.Bindings = append(t.Bindings, Binding{Queue: name, Exchange: cfg.Exchanges.DLX, RoutingKey: name})
+		if tier == 0 {
+			t.Bindings = append(t.Bindings, Binding{Queue: name, Exchange: cfg.Exchanges.DLX, RoutingKey: constants.RoutingKeyRetry})
+		}
+	}
+}
+
+// Apply declares the whole topology, declaring what already exists with the
+// same arguments is a no-op. It matches rabbitmq.Topology.
+func (t Topology) Apply(ch *amqp.Channel) error {
+	for _, exchange := range t.Exchanges {
+		err := ch.ExchangeDeclare(exchange.Name, exchange.Kind, true, false, false, false, nil)
+		if err != nil {
+			return fmt.Errorf("can't create exchange %s: %w", exchange.Name, err)
+		}
+	}
+
+	for _, queue := range t.Queues {
+		_, err := ch.QueueDeclare(queue.Name, true, false, false, false, queue.Args)
+		if err != nil {
+			return fmt.Errorf("can't create queue %s: %w", queue.Name, err)
+		}
+	}
+
+	for _, binding := range t.Bindings {
+		err := ch.QueueBind(binding.Queue, binding.RoutingKey, binding.Exchange, false, nil)
+		if err != nil {
+			return fmt.Errorf("can't bind queue %s to exchange %s: %w", binding.Queue, binding.Exchange, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"order_processing/client"
+	"order_processing/config"
+	"order_processing/constants"
+	"order_processing/entity"
+	"order_processing/messaging"
+	"order_processing/rabbitmq"
+	"order_processing/repository"
+	"order_processing/topology"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var cfg *config.Config
+
+func main() {
+	cfg = config.MustLoad()
+
+	db, err := client.PostgresPool(context.Background(), cfg.Database)
+	rabbitmq.FailOnError(err, "can't connect to database")
+	defer db.Close()
+	orderRepository := repository.NewOrderRepository(db)
+	inventoryRepository := repository.NewInventoryRepository(db)
+
+	conn, err := rabbitmq.Dial(context.Background(), cfg.RabbitMQ.URL, topology.New(cfg).Apply)
+	rabbitmq.FailOnError(err, "Failed to connect to RabbitMQ")
+	defer conn.Close()
+	publisher := conn.NewPublisher(5 * time.Second)
+	defer publisher.Close()
+
+	retrier := &rabbitmq.Retrier{
+		Publisher:   publisher,
+		Store:       orderRepository,
+		Exchange:    cfg.Exchanges.DLX,
+		RoutingKey:  constants.RoutingKeyInventoryRetry,
+		MaxRetries:  cfg.Retry.MaxRetries,
+		Service:     "inventory",
+		SourceQueue: cfg.Queues.Inventory,
+	}
+
+	log.Printf(" [*] Waiting for orders to reserve stock for. To exit press CTRL+C")
+	rabbitmq.Serve(cfg.ShutdownTimeout, func(ctx, workCtx context.Context) {
+		listenOrderCreated(ctx, workCtx, conn, publisher, orderRepository, inventoryRepository, retrier)
+	})
+}
+
+func listenOrderCreated(ctx, workCtx context.Context, conn *rabbitmq.Connection, publisher *rabbitmq.ReconnectingPublisher, orderRepository repository.OrderRepository, inventoryRepository repository.InventoryRepository, retrier *rabbitmq.Retrier) {
+	conn.ConsumeWorkers(ctx, cfg.Queues.Inventory, cfg.InventoryWorker.Prefetch, cfg.InventoryWorker.Concurrency, func(d amqp.Delivery) {
+		processOrderCreated(workCtx, d, publisher, orderRepository, inventoryRepository, retrier)
+	})
+}
+
+// Reserve the stock of a new order. Payment starts on stock.reserved, an order
+// that can't be served is cancelled before anything is charged.
+func processOrderCreated(ctx context.Context, d amqp.Delivery, publisher *rabbitmq.ReconnectingPublisher, orderRepository repository.OrderRepository, inventoryRepository repository.InventoryRepository, retrier *rabbitmq.Retrier) {
+	log.Printf(" [x] %s", d.Body)
+	var orderCreated entity.OrderCreatedEvent
+	message, err := messaging.Decode(d.Body, messaging.TypeOrderCreated, &orderCreated)
+	if err != nil {
+		rabbitmq.Park(ctx, publisher, d, cfg.Queues.ParkingLot, cfg.Queues.Inventory, err.Error())
+		return
+	}
+
+	// a redelivered order gets the outcome of its first reservation, so the
+	// event is published again without reserving twice
+	reservation, available, err := inventoryRepository.ReserveStock(ctx, &entity.StockReservation{
+		UserOrderID: orderCreated.UserOrderID,
+		ProductID:   orderCreated.ProductID,
+		Location:    orderCreated.Location,
+		Quantity:    orderCreated.Quantity,
+		CreatedAt:   time.Now(),
+	})
+	if err == nil {
+		switch reservation.Status {
+		case entity.ReservationReserved:
+			log.Printf("reserved %d of %s at %s for user order %s", reservation.Quantity, reservation.ProductID, reservation.Location, reservation.UserOrderID)
+			err = publishStockReserved(ctx, publisher, message.CorrelationID, &orderCreated, reservation)
+		case entity.ReservationInsufficient:
+			log.Printf("insufficient stock of %s at %s for user order %s, %d available", reservation.ProductID, reservation.Location, reservation.UserOrderID, available)
+			err = cancelUserOrder(ctx, publisher, orderRepository, message.CorrelationID, &orderCreated, available)
+		default:
+			log.Printf("reservation of user order %s is %s, skipping", reservation.UserOrderID, reservation.Status)
+		}
+	}
+	if err != nil {
+		// reserving is idempotent, so a failed publish is retried as well
+		// rather than leaking the reservation
+		retrier.HandleFailure(ctx, d, orderCreated.UserOrderID.String(), rabbitmq.Retryable(err, repository.IsTransient), err)
+		return
+	}
+
+	d.Ack(false)
+}
+
+func cancelUserOrder(ctx context.Context, publisher *rabbitmq.ReconnectingPublisher, orderRepository repository.OrderRepository, correlationID string, orderCreated *entity.OrderCreatedEvent, available int) error {
+	err := orderRepository.UpdateStatusUserOrder(ctx, orderCreated.UserOrderID.String(), entity.StatusCancelled)
+	if errors.Is(err, entity.ErrInvalidTransition) {
+		log.Printf("user order %s can't be cancelled anymore: %v", orderCreated.UserOrderID, err)
+	} else if err != nil {
+		return err
+	}
+
+	message, err := messaging.New(messaging.TypeStockInsufficient, correlationID, entity.StockInsufficientEvent{
+		UserOrderID: orderCreated.UserOrderID,
+		UserID:      orderCreated.UserID,
+		ProductID:   orderCreated.ProductID,
+		Quantity:    orderCreated.Quantity,
+		Location:    orderCreated.Location,
+		Available:   available,
+	})
+	if err != nil {
+		return err
+	}
+	return publishEvent(ctx, publisher, constants.RoutingKeyStockInsufficient, message)
+}
+
+func publishStockReserved(ctx context.Context, publisher *rabbitmq.ReconnectingPublisher, correlationID string, orderCreated *entity.OrderCreatedEvent, reservation *entity.StockReservation) error {
+	message, err := messaging.New(messaging.TypeStockReserved, correlationID, entity.StockReservedEvent{
+		UserOrderID: orderCreated.UserOrderID,
+		UserID:      orderCreated.UserID,
+		ProductID:   reservation.ProductID,
+		Quantity:    reservation.Quantity,
+		Location:    reservation.Location,
+		ReservedAt:  reservation.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	return publishEvent(ctx, publisher, constants.RoutingKeyStockReserved, message)
+}
+
+func publishEvent(ctx context.Context, publisher *rabbitmq.ReconnectingPublisher, routingKey string, message *messaging.Envelope) error {
+	publishing, err := message.Publishing()
+	if err != nil {
+		return err
+	}
+	if err := publisher.Publish(ctx, cfg.Exchanges.OrderEvents, routingKey, publishing); err != nil {
+		return err
+	}
+	log.Printf("%s: [x] Sent %s", message.Type, publishing.Body)
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"order_processing/client"
+	"order_processing/config"
+	"order_processing/constants"
+	"order_processing/entity"
+	"order_processing/messaging"
+	"order_processing/notification"
+	"order_processing/rabbitmq"
+	"order_processing/repository"
+	"order_processing/topology"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var cfg *config.Config
+
+func main() {
+	cfg = config.MustLoad()
+
+	db, err := client.PostgresPool(context.Background(), cfg.Database)
+	rabbitmq.FailOnError(err, "can't connect to database")
+	defer db.Close()
+	orderRepository := repository.NewOrderRepository(db)
+	notificationRepository := repository.NewNotificationRepository(db)
+
+	notifier, err := newNotifier(cfg.Notifier)
+	rabbitmq.FailOnError(err, "can't create notifier")
+
+	conn, err := rabbitmq.Dial(context.Background(), cfg.RabbitMQ.URL, topology.New(cfg).Apply)
+	rabbitmq.FailOnError(err, "Failed to connect to RabbitMQ")
+	defer conn.Close()
+	publisher := conn.NewPublisher(5 * time.Second)
+	defer publisher.Close()
+
+	retrier := &rabbitmq.Retrier{
+		Publisher:   publisher,
+		Store:       orderRepository,
+		Exchange:    cfg.Exchanges.DLX,
+		RoutingKey:  constants.RoutingKeyNotificationRetry,
+		MaxRetries:  cfg.Retry.MaxRetries,
+		Service:     "notification",
+		SourceQueue: cfg.Queues.Notification,
+	}
+
+	log.Printf(" [*] Waiting for events to notify through %s. To exit press CTRL+C", cfg.Notifier.Name)
+	rabbitmq.Serve(cfg.ShutdownTimeout, func(ctx, workCtx context.Context) {
+		listenEvents(ctx, workCtx, conn, publisher, notifier, notificationRepository, retrier)
+	})
+}
+
+// Picks the notifier by name: log, smtp or webhook
+func newNotifier(notifierConfig config.NotifierConfig) (notification.Notifier, error) {
+	switch notifierConfig.Name {
+	case "log":
+		return notification.NewLogNotifier(), nil
+	case "smtp":
+		smtp := notifierConfig.SMTP
+		return notification.NewSMTPNotifier(smtp.Addr, smtp.Username, smtp.Password, smtp.From, smtp.RecipientDomain, smtp.Timeout), nil
+	case "webhook":
+		return notification.NewWebhookNotifier(notifierConfig.Webhook.URL, notifierConfig.Webhook.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", notifierConfig.Name)
+	}
+}
+
+func listenEvents(ctx, workCtx context.Context, conn *rabbitmq.Connection, publisher *rabbitmq.ReconnectingPublisher, notifier notification.Notifier, notificationRepository repository.NotificationRepository, retrier *rabbitmq.Retrier) {
+	conn.ConsumeWorkers(ctx, cfg.Queues.Notification, cfg.NotificationWorker.Prefetch, cfg.NotificationWorker.Concurrency, func(d amqp.Delivery) {
+		processEvent(workCtx, d, publisher, notifier, notificationRepository, retrier)
+	})
+}
+
+// Notify the user of an order or payment event. The event is claimed before
+// the notification is sent, so a redelivered or republished event is acked
+// without sending it again.
+func processEvent(ctx context.Context, d amqp.Delivery, publisher *rabbitmq.ReconnectingPublisher, notifier notification.Notifier, notificationRepository repository.NotificationRepository, retrier *rabbitmq.Retrier) {
+	// the queue carries several event types, the AMQP type tells which one
+	// the envelope has to be
+	var payload map[string]any
+	message, err := messaging.Decode(d.Body, d.Type, &payload)
+	if err != nil {
+		rabbitmq.Park(ctx, publisher, d, cfg.Queues.ParkingLot, cfg.Queues.Notification, err.Error())
+		return
+	}
+
+	subject, body, err := notification.Render(message.Type, payload)
+	if errors.Is(err, notification.ErrNoTemplate) {
+		log.Printf("no notification for %s, skipping", message.Type)
+		d.Ack(false)
+		return
+	}
+	if err != nil {
+		rabbitmq.Park(ctx, publisher, d, cfg.Queues.ParkingLot, cfg.Queues.Notification, err.Error())
+		return
+	}
+
+	replayedFrom, _ := d.Headers[rabbitmq.HeaderReplayedFrom].(string)
+	eventID, err := notification.EventID(message.Type, payload, replayedFrom)
+	if err != nil {
+		rabbitmq.Park(ctx, publisher, d, cfg.Queues.ParkingLot, cfg.Queues.Notification, err.Error())
+		return
+	}
+
+	// every notified event carries the user and the user order
+	userID, _ := payload["user_id"].(string)
+	userOrderID, _ := payload["user_order_id"].(string)
+	n := &entity.Notification{
+		EventID:     eventID,
+		Type:        message.Type,
+		UserID:      userID,
+		UserOrderID: userOrderID,
+		Subject:     subject,
+		Body:        body,
+		SentAt:      time.Now(),
+	}
+
+	err = notification.Send(ctx, notificationRepository, notifier, n)
+	if errors.Is(err, notification.ErrAlreadySent) {
+		log.Printf("%s notification %s was sent already, skipping", n.Type, n.EventID)
+		d.Ack(false)
+		return
+	}
+	if err != nil {
+		retrier.HandleFailure(ctx, d, n.UserOrderID, true, err)
+		return
+	}
+	log.Printf("sent %s notification %s to user %s", n.Type, n.EventID, n.UserID)
+	d.Ack(false)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"order_processing/client"
+	"order_processing/config"
+	"order_processing/entity"
+	"order_processing/rabbitmq"
+	"order_processing/repository"
+	"order_processing/topology"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	outboxBatchSize       = 100
+	outboxPollInterval    = 1 * time.Second
+	publishConfirmTimeout = 5 * time.Second
+)
+
+var cfg *config.Config
+
+func main() {
+	cfg = config.MustLoad()
+
+	conn, err := rabbitmq.Dial(context.Background(), cfg.RabbitMQ.URL, topology.New(cfg).Apply)
+	rabbitmq.FailOnError(err, "Failed to connect to RabbitMQ")
+	defer conn.Close()
+	publisher := conn.NewPublisher(publishConfirmTimeout)
+	defer publisher.Close()
+
+	db, err := client.PostgresPool(context.Background(), cfg.Database)
+	rabbitmq.FailOnError(err, "can't connect to database")
+	defer db.Close()
+	outboxRepository := repository.NewOutboxRepository(db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf(" [*] Relaying outbox every %s. To exit press CTRL+C", outboxPollInterval)
+	for ctx.Err() == nil {
+		sent := relayOutbox(ctx, publisher, outboxRepository)
+		if sent < outboxBatchSize {
+			select {
+			case <-time.After(outboxPollInterval):
+			case <-ctx.Done():
+			}
+		}
+	}
+	log.Printf(" [*] Shutting down")
+}
+
+// Publish a batch of pending outbox rows and return how many were sent
+// The row being published when ctx is done is still finished
+func relayOutbox(ctx context.Context, publisher *rabbitmq.ReconnectingPublisher, outboxRepository repository.OutboxRepository) int {
+	batch, err := outboxRepository.ClaimPendingOutbox(context.Background(), outboxBatchSize)
+	if err != nil {
+		log.Printf("unable to claim pending outbox: %v", err)
+		return 0
+	}
+	defer batch.Rollback(context.Background())
+
+	sent := 0
+	for _, outbox := range batch.Outboxes() {
+		if ctx.Err() != nil {
+			break
+		}
+		err := publishOutbox(publisher, &outbox)
+		if err != nil {
+			log.Printf("unable to publish outbox %s: %v", outbox.ID, err)
+			dead, err := batch.MarkFailed(context.Background(), outbox.ID.String(), err, cfg.Outbox.MaxAttempts)
+			if err != nil {
+				log.Printf("unable to mark outbox %s failed: %v", outbox.ID, err)
+				break
+			}
+			if dead {
+				// give up on the row so it doesn't hold back the ones behind it
+				log.Printf("outbox %s is dead after %d attempts", outbox.ID, outbox.Attempts+1)
+				continue
+			}
+			// keep ordering, the rest of the batch is retried on the next poll
+			break
+		}
+
+		// message is already published, a failure here only means it is sent again
+		if err := batch.MarkSent(context.Background(), outbox.ID.String()); err != nil {
+			log.Printf("unable to mark outbox %s sent: %v", outbox.ID, err)
+			break
+		}
+		sent++
+		log.Printf("Outbox: [x] Sent %s to %s", outbox.Payload, outbox.Exchange)
+	}
+
+	if err := batch.Commit(context.Background()); err != nil {
+		log.Printf("unable to commit outbox batch, its rows are sent again: %v", err)
+		return 0
+	}
+	return sent
+}
+
+func publishOutbox(publisher *rabbitmq.ReconnectingPublisher, outbox *entity.Outbox) error {
+	return publisher.Publish(context.Background(),
+		outbox.Exchange,
+		outbox.RoutingKey,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			MessageId:    outbox.ID.String(),
+			Body:         outbox.Payload,
+			DeliveryMode: amqp.Persistent,
+		})
+}
@@ -3,220 +3,277 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
-	"math/rand"
 	"strings"
 	"time"
 
 	"order_processing/client"
+	"order_processing/config"
 	"order_processing/constants"
 	"order_processing/entity"
+	"order_processing/gateway"
+	"order_processing/messaging"
 	"order_processing/rabbitmq"
 	"order_processing/repository"
+	"order_processing/retry"
+	"order_processing/topology"
 
 	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// Track payment attempt scenarios for simulation
-var paymentScenarios = map[string]string{} // paymentID -> scenario type
+var cfg *config.Config
 
 func main() {
-	// Seed random for simulation
-	rand.Seed(time.Now().UnixNano())
+	cfg = config.MustLoad()
 
-	conn := rabbitmq.RabbitMQSetup()
+	db, err := client.PostgresPool(context.Background(), cfg.Database)
+	rabbitmq.FailOnError(err, "can't connect to database")
+	defer db.Close()
+	orderRepository := repository.NewOrderRepository(db)
+
+	paymentGateway, err := newPaymentGateway(cfg.PaymentGateway)
+	rabbitmq.FailOnError(err, "can't create payment gateway")
+
+	conn, err := rabbitmq.Dial(context.Background(), cfg.RabbitMQ.URL, topology.New(cfg).Apply)
+	rabbitmq.FailOnError(err, "Failed to connect to RabbitMQ")
 	defer conn.Close()
-	ch := rabbitmq.GetChannel(conn)
-	defer ch.Close()
-
-	// PAYMENT SETUP
-	// =======================================================================================
-
-	// make exchange payment
-	err := ch.ExchangeDeclare(constants.ExchangePaymentDirect, "direct", true, false, false, false, nil)
-	rabbitmq.FailOnError(err, "can't create exchange user order")
-
-	// FIXED: x-dead-letter arguments should be in QueueDeclare, not QueueBind
-	paymentQueue, err := ch.QueueDeclare(
-		constants.PaymentQueue,
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		amqp.Table{
-			"x-dead-letter-exchange":    constants.ExchangeDLX,
-			"x-dead-letter-routing-key": constants.RoutingKeyRetry,
-		},
-	)
-	rabbitmq.FailOnError(err, "can't create payment queue")
-
-	// bind payment queue to payment exchange (no extra args here)
-	err = ch.QueueBind(paymentQueue.Name, constants.RoutingKeyPayment, constants.ExchangePaymentDirect, false, nil)
-	rabbitmq.FailOnError(err, "can't bind payment queue to payment exchange")
-
-	// DLQ SETUP
-	// =======================================================================================
-
-	// make exchange dlx
-	err = ch.ExchangeDeclare(constants.ExchangeDLX, "direct", true, false, false, false, nil)
-	rabbitmq.FailOnError(err, "can't create exchange dlx")
-
-	// FIXED: x-dead-letter and x-message-ttl should be in QueueDeclare
-	retryQueue, err := ch.QueueDeclare(
-		constants.RoutingKeyRetry,
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		amqp.Table{
-			"x-dead-letter-exchange":    constants.ExchangePaymentDirect,
-			"x-dead-letter-routing-key": constants.RoutingKeyPayment,
-			"x-message-ttl":             constants.RetryDelaySeconds * 1000, // 5000ms
-		},
-	)
-	rabbitmq.FailOnError(err, "can't create retry queue")
-
-	// bind dlx queue to dlx exchange (no extra args here)
-	err = ch.QueueBind(retryQueue.Name, constants.RoutingKeyRetry, constants.ExchangeDLX, false, nil)
-	rabbitmq.FailOnError(err, "can't bind retry queue to dlx exchange")
+	publisher := conn.NewPublisher(5 * time.Second)
+	defer publisher.Close()
 
 	log.Println("✅ Topology setup complete")
 	log.Println("\n🎬 Payment Worker Ready!")
 	log.Println("📋 Possible Scenarios:")
 	log.Println("   1️⃣  SUCCESS: Payment succeeds on first attempt")
 	log.Println("   2️⃣  RETRY: Payment fails initially, succeeds after retry")
-	log.Println("   3️⃣  DLX: Payment fails all 3 retries, stored in DLX table\n")
+	log.Println("   3️⃣  DLX: Payment fails all 3 retries, stored in DLX table")
+
+	refundRetrier := &rabbitmq.Retrier{
+		Publisher:   publisher,
+		Store:       orderRepository,
+		Exchange:    cfg.Exchanges.DLX,
+		RoutingKey:  constants.RoutingKeyPaymentRefundRetry,
+		MaxRetries:  cfg.Retry.MaxRetries,
+		Service:     "payment_refund",
+		SourceQueue: cfg.Queues.PaymentRefund,
+	}
 
-	// Start listening
-	listenUserOrder(ch, paymentQueue)
+	log.Printf(" [*] Waiting for messages with %d consumers. To exit press CTRL+C", cfg.PaymentWorker.Concurrency)
+	rabbitmq.Serve(cfg.ShutdownTimeout, func(ctx, workCtx context.Context) {
+		refundsDone := make(chan struct{})
+		go func() {
+			listenRefunds(ctx, workCtx, conn, publisher, paymentGateway, orderRepository, refundRetrier)
+			close(refundsDone)
+		}()
+		listenUserOrder(ctx, workCtx, conn, publisher, paymentGateway, orderRepository)
+		<-refundsDone
+	})
 }
 
-func listenUserOrder(ch *amqp.Channel, paymentQueue amqp.Queue) {
-	msgs, err := ch.Consume(
-		paymentQueue.Name, // queue
-		"",                // consumer
-		false,             // auto ack - MUST be false
-		false,             // exclusive
-		false,             // no local
-		false,             // no wait
-		nil,               // args
-	)
-	rabbitmq.FailOnError(err, "Failed to register a consumer")
-	var forever chan struct{}
-
-	go func() {
-		for d := range msgs {
-			retryCount := getRetryCount(d.Headers)
-			attemptNum := retryCount + 1
-			log.Printf("\n" + strings.Repeat("=", 80))
-			log.Printf("📨 Received message: %s", d.Body)
-			log.Printf("🔄 Retry count: %d (Attempt #%d/%d)", retryCount, attemptNum, constants.MaxRetries+1)
-
-			var userOrderID string
-			err := json.Unmarshal(d.Body, &userOrderID)
-			if err != nil {
-				log.Printf("❌ Unable to unmarshal payment: %v", err)
-				d.Ack(false) // Acknowledge to remove malformed message
-				continue
-			}
+// Picks the gateway by name: simulator, fake or http
+func newPaymentGateway(gatewayConfig config.PaymentGatewayConfig) (gateway.PaymentGateway, error) {
+	switch gatewayConfig.Name {
+	case "simulator":
+		return gateway.NewSimulatorGateway(4 * time.Second), nil
+	case "fake":
+		return gateway.NewFakeGateway(), nil
+	case "http":
+		return gateway.NewHTTPGateway(gatewayConfig.URL, gatewayConfig.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", gatewayConfig.Name)
+	}
+}
 
-			// create payment record
-			payment, err := createPayment(userOrderID)
-			if err != nil {
-				log.Printf("❌ Failed to create payment: %v", err)
-				d.Nack(false, false) // Don't requeue, send to DLX if configured
-				continue
-			}
+func listenUserOrder(ctx, workCtx context.Context, conn *rabbitmq.Connection, publisher *rabbitmq.ReconnectingPublisher, paymentGateway gateway.PaymentGateway, orderRepository repository.OrderRepository) {
+	// payments wait on the gateway, so several are processed at once
+	conn.ConsumeWorkers(ctx, cfg.Queues.Payment, cfg.PaymentWorker.Prefetch, cfg.PaymentWorker.Concurrency, func(d amqp.Delivery) {
+		processPayment(workCtx, d, publisher, paymentGateway, orderRepository)
+	})
+}
 
-			// call paymentService with proper error handling
-			err = paymentService(payment, retryCount)
+func processPayment(ctx context.Context, d amqp.Delivery, publisher *rabbitmq.ReconnectingPublisher, paymentGateway gateway.PaymentGateway, orderRepository repository.OrderRepository) {
+	retryState := rabbitmq.ReadRetryState(d.Headers)
+	retryCount := retryState.Attempt
+	attemptNum := retryCount + 1
+	log.Print("\n" + strings.Repeat("=", 80))
+	log.Printf("📨 Received message: %s", d.Body)
+	log.Printf("🔄 Retry count: %d (Attempt #%d/%d)", retryCount, attemptNum, cfg.Retry.MaxRetries+1)
+	if retryCount > 0 {
+		log.Printf("   ⏱️  First failed at %s, last error: %s", retryState.FirstFailedAt.Format(time.RFC3339), retryState.LastError)
+	}
 
-			if err != nil {
-				log.Printf("❌ Payment failed: %v", err)
+	var stockReserved entity.StockReservedEvent
+	message, err := messaging.Decode(d.Body, messaging.TypeStockReserved, &stockReserved)
+	if err != nil {
+		log.Printf("❌ Unable to decode stock reserved event: %v", err)
+		rabbitmq.Park(ctx, publisher, d, cfg.Queues.ParkingLot, cfg.Queues.Payment, err.Error())
+		return
+	}
 
-				// Check if max retries exceeded
-				if retryCount >= constants.MaxRetries {
-					log.Printf("🚫 Max retries (%d) reached. Storing in DLX table.", constants.MaxRetries)
+	// create payment record
+	payment, err := createPayment(ctx, orderRepository, stockReserved.UserOrderID.String())
+	if errors.Is(err, entity.ErrInvalidTransition) {
+		log.Printf("⏭️  Skipping payment, order is already settled: %v", err)
+		d.Ack(false)
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		log.Printf("⏹️  Shutting down, requeueing message")
+		d.Nack(false, true)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to create payment: %v", err)
+		scheduleRetry(ctx, d, publisher, retryState.Failed(err))
+		return
+	}
 
-					// Store DLX record
-					storeDLXRecord(payment.ID.String(), retryCount, err)
+	// charge through the payment gateway
+	err = chargePayment(ctx, paymentGateway, payment)
+	if err != nil && ctx.Err() != nil {
+		// aborted by shutdown, not a real attempt
+		log.Printf("⏹️  Shutting down, requeueing message")
+		d.Nack(false, true)
+		return
+	}
+	recordPaymentAttempt(ctx, orderRepository, payment, attemptNum, err)
 
-					// Clean up scenario tracking
-					delete(paymentScenarios, payment.ID.String())
+	if err != nil {
+		log.Printf("❌ Payment failed: %v", err)
 
-					// Acknowledge to remove from queue
-					d.Ack(false)
-				} else {
-					log.Printf("🔁 Rejecting message. Will retry after %d seconds...", constants.RetryDelaySeconds)
-					// Reject with requeue=false to trigger DLX
-					d.Reject(false)
-				}
+		// Check if max retries exceeded or the gateway refused for good
+		retryable := gateway.IsRetryable(err)
+		if retryCount >= cfg.Retry.MaxRetries || !retryable {
+			if retryable {
+				log.Printf("🚫 Max retries (%d) reached. Storing in DLX table.", cfg.Retry.MaxRetries)
 			} else {
-				log.Printf("✅ Payment succeeded! 🎉")
+				log.Printf("🚫 Terminal gateway error. Storing in DLX table.")
+			}
 
-				// Clean up scenario tracking
-				delete(paymentScenarios, payment.ID.String())
+			// Store DLX record, the message must not be lost without it
+			if err := storeDLXRecord(ctx, orderRepository, d, payment, retryCount, err); err != nil {
+				log.Printf("⚠️ Failed to insert DLX record, requeueing: %v", err)
+				rabbitmq.Requeue(ctx, d)
+				return
+			}
 
-				d.Ack(false)
+			// payment is given up, cancel the order
+			if err := updateUserOrderStatus(ctx, orderRepository, payment.UserOrderID, entity.StatusCancelled); err != nil {
+				log.Printf("⚠️ Failed to cancel user order: %v", err)
 			}
-			log.Printf(strings.Repeat("=", 80) + "\n")
+			publishPaymentEvent(ctx, publisher, d, messaging.TypePaymentDeadLettered, constants.RoutingKeyPaymentDeadLettered, message.CorrelationID, entity.PaymentDeadLetteredEvent{
+				UserOrderID: stockReserved.UserOrderID,
+				PaymentID:   payment.ID,
+				UserID:      stockReserved.UserID,
+				ProductID:   stockReserved.ProductID,
+				Quantity:    stockReserved.Quantity,
+				Attempts:    attemptNum,
+				Error:       err.Error(),
+				FailedAt:    time.Now(),
+			})
+
+			// Acknowledge to remove from queue
+			d.Ack(false)
+		} else {
+			if err := updateUserOrderStatus(ctx, orderRepository, payment.UserOrderID, entity.StatusPaymentFailed); err != nil {
+				log.Printf("⚠️ Failed to mark user order payment failed: %v", err)
+			}
+			publishPaymentEvent(ctx, publisher, d, messaging.TypePaymentFailed, constants.RoutingKeyPaymentFailed, message.CorrelationID, entity.PaymentFailedEvent{
+				UserOrderID: stockReserved.UserOrderID,
+				PaymentID:   payment.ID,
+				UserID:      stockReserved.UserID,
+				ProductID:   stockReserved.ProductID,
+				Quantity:    stockReserved.Quantity,
+				Attempt:     attemptNum,
+				Error:       err.Error(),
+				FailedAt:    time.Now(),
+			})
+
+			scheduleRetry(ctx, d, publisher, retryState.Failed(err))
 		}
-	}()
-
-	log.Printf(" [*] Waiting for messages. To exit press CTRL+C")
-	<-forever
+	} else {
+		log.Printf("✅ Payment succeeded! 🎉")
+
+		err := updateUserOrderStatus(ctx, orderRepository, payment.UserOrderID, entity.StatusPurchased)
+		if errors.Is(err, entity.ErrInvalidTransition) {
+			// the saga timed out and cancelled the order while it was charged,
+			// payment.succeeded gets the charge refunded
+			log.Printf("⚠️ User order was cancelled while charging: %v", err)
+		} else if err != nil {
+			log.Printf("❌ Failed to mark user order purchased: %v", err)
+			// retry so the order doesn't stay in payment processing
+			scheduleRetry(ctx, d, publisher, retryState.Failed(err))
+			return
+		}
+		publishPaymentEvent(ctx, publisher, d, messaging.TypePaymentSucceeded, constants.RoutingKeyPaymentSucceeded, message.CorrelationID, entity.PaymentSucceededEvent{
+			UserOrderID: stockReserved.UserOrderID,
+			PaymentID:   payment.ID,
+			UserID:      stockReserved.UserID,
+			ProductID:   stockReserved.ProductID,
+			Quantity:    stockReserved.Quantity,
+			PaidAt:      time.Now(),
+		})
+
+		d.Ack(false)
+	}
+	log.Print(strings.Repeat("=", 80) + "\n")
 }
 
-// Simulates different payment scenarios
-func paymentService(payment *entity.Payment, retryCount int) error {
-	log.Printf("💳 Starting payment service for payment ID: %s", payment.ID)
-
-	// payment logic takes 4 seconds
-	time.Sleep(4 * time.Second)
-
-	// Get or assign scenario for this payment
-	scenario, exists := paymentScenarios[payment.ID.String()]
-	if !exists {
-		// Randomly assign scenario for new payments
-		scenarios := []string{"success", "retry", "dlx"}
-		scenario = scenarios[rand.Intn(len(scenarios))]
-		paymentScenarios[payment.ID.String()] = scenario
-		log.Printf("🎲 Assigned scenario: %s", strings.ToUpper(scenario))
+// Move the message to the retry tier of this attempt with its retry headers
+// updated, the backoff grows with every retry
+func scheduleRetry(ctx context.Context, d amqp.Delivery, publisher *rabbitmq.ReconnectingPublisher, retryState rabbitmq.RetryState) {
+	policy := retry.PaymentPolicy
+	tier := policy.Tier(retryState.Attempt - 1)
+	delay := policy.Delay(tier)
+	queue := policy.QueueName(cfg.Queues.Retry, tier)
+
+	// the DLX routes every tier by its queue name
+	err := rabbitmq.Republish(ctx, publisher, d, cfg.Exchanges.DLX, queue, retryState, retry.Expiration(delay))
+	if err != nil {
+		// requeued as it is, the attempt isn't counted
+		log.Printf("⚠️ Failed to schedule retry on %s, requeueing: %v", queue, err)
+		rabbitmq.Requeue(ctx, d)
+		return
 	}
 
-	// Execute based on scenario
-	switch scenario {
-	case "success":
-		// Case 1: SUCCESS - Succeeds on first attempt
-		log.Println("✅ [SCENARIO: SUCCESS] Payment processed successfully!")
-		return nil
-
-	case "retry":
-		// Case 2: RETRY - Fails initially, succeeds on 2nd attempt
-		if retryCount < 1 {
-			log.Println("⚠️  [SCENARIO: RETRY] Payment gateway timeout - will retry")
-			return errors.New("payment service error: gateway timeout")
-		}
-		log.Println("✅ [SCENARIO: RETRY] Payment succeeded after retry!")
-		return nil
-
-	case "dlx":
-		// Case 3: DLX - Fails all attempts
-		log.Printf("❌ [SCENARIO: DLX] Payment failed (attempt %d/%d)", retryCount+1, constants.MaxRetries+1)
-		if retryCount >= constants.MaxRetries {
-			return errors.New("payment service error: persistent gateway failure - max retries exceeded")
-		}
-		return errors.New("payment service error: gateway unavailable")
+	log.Printf("🔁 Will retry after %s (%s)...", delay.Round(time.Second), queue)
+	d.Ack(false)
+}
 
-	default:
-		return errors.New("unknown scenario")
+// A failed publish of an outcome event is logged and doesn't change how the
+// payment is handled. Events of a
+// replayed payment carry the dlx record it was replayed from, so they aren't
+// taken for the events of the attempts before the replay.
+func publishPaymentEvent(ctx context.Context, publisher *rabbitmq.ReconnectingPublisher, d amqp.Delivery, msgType, routingKey, correlationID string, payload any) {
+	message, err := messaging.New(msgType, correlationID, payload)
+	if err != nil {
+		log.Printf("⚠️ Failed to create %s event: %v", msgType, err)
+		return
 	}
+	publishing, err := message.Publishing()
+	if err != nil {
+		log.Printf("⚠️ Failed to encode %s event: %v", msgType, err)
+		return
+	}
+	if replayedFrom, ok := d.Headers[rabbitmq.HeaderReplayedFrom]; ok {
+		publishing.Headers = amqp.Table{rabbitmq.HeaderReplayedFrom: replayedFrom}
+	}
+	if err := publisher.Publish(ctx, cfg.Exchanges.OrderEvents, routingKey, publishing); err != nil {
+		log.Printf("⚠️ Failed to publish %s event: %v", msgType, err)
+		return
+	}
+	log.Printf("📤 Sent %s event", msgType)
 }
 
-func createPayment(userOrderID string) (*entity.Payment, error) {
+func chargePayment(ctx context.Context, paymentGateway gateway.PaymentGateway, payment *entity.Payment) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return paymentGateway.Charge(ctx, payment)
+}
+
+func createPayment(ctx context.Context, orderRepository repository.OrderRepository, userOrderID string) (*entity.Payment, error) {
 	var payment entity.Payment
 	var err error
 	payment.ID, err = uuid.NewV7()
@@ -226,78 +283,89 @@ func createPayment(userOrderID string) (*entity.Payment, error) {
 	payment.UserOrderID = userOrderID
 	payment.CreatedAt = time.Now()
 
-	db := client.PostgresClient(constants.Username, constants.Password, constants.Host, constants.Port, constants.DBName)
-	orderRepository := repository.NewOrderRepository(db)
-	defer db.Close(context.Background())
-
-	err = orderRepository.InsertPayment(context.Background(), &payment)
+	// update user order table, fails for orders that are already purchased or cancelled
+	err = orderRepository.UpdateStatusUserOrder(ctx, payment.UserOrderID, entity.StatusPaymentProcessing)
 	if err != nil {
 		return nil, err
 	}
-	log.Println("💾 Payment record inserted with ID:", payment.ID)
 
-	// update user order table
-	err = orderRepository.UpdateStatusUserOrder(context.Background(), payment.UserOrderID, entity.StatusPurchased)
+	// retries and redeliveries get back the payment created on the first attempt
+	stored, err := orderRepository.UpsertPayment(ctx, &payment)
 	if err != nil {
 		return nil, err
 	}
+	log.Println("💾 Payment record stored with ID:", stored.ID)
 
-	return &payment, nil
+	return stored, nil
 }
 
-func storeDLXRecord(paymentID string, retryCount int, err error) {
-	db := client.PostgresClient(constants.Username, constants.Password, constants.Host, constants.Port, constants.DBName)
-	orderRepository := repository.NewOrderRepository(db)
-	defer db.Close(context.Background())
-
-	dlx := &entity.DLX{
-		ID:              uuid.Must(uuid.NewV7()),
-		PaymentID:       paymentID,
-		NumberOfRetries: retryCount,
-		IsReplayed:      false,
-		ServiceName:     "payment",
-		Error:           err.Error(),
-		CreatedAt:       time.Now(),
+func recordPaymentAttempt(ctx context.Context, orderRepository repository.OrderRepository, payment *entity.Payment, attemptNum int, paymentErr error) {
+	attempt := &entity.PaymentAttempt{
+		ID:        uuid.Must(uuid.NewV7()),
+		PaymentID: payment.ID,
+		Attempt:   attemptNum,
+		Status:    entity.PaymentAttemptSucceeded,
+		CreatedAt: time.Now(),
+	}
+	if paymentErr != nil {
+		errMsg := paymentErr.Error()
+		attempt.Status = entity.PaymentAttemptFailed
+		attempt.Error = &errMsg
 	}
 
-	if err := orderRepository.InsertDLX(context.Background(), dlx); err != nil {
-		log.Printf("⚠️ Failed to insert DLX record: %v", err)
-	} else {
-		log.Printf("💾 DLX record stored successfully!")
-		log.Printf("   📝 DLX ID: %s", dlx.ID)
-		log.Printf("   💳 Payment ID: %s", dlx.PaymentID)
-		log.Printf("   🔄 Total Retries: %d", dlx.NumberOfRetries)
-		log.Printf("   ⚠️  Error: %s", dlx.Error)
+	if err := orderRepository.InsertPaymentAttempt(ctx, attempt); err != nil {
+		log.Printf("⚠️ Failed to record payment attempt #%d: %v", attemptNum, err)
 	}
 }
 
-func getRetryCount(headers amqp.Table) int {
-	if headers == nil {
-		return 0
+func updateUserOrderStatus(ctx context.Context, orderRepository repository.OrderRepository, userOrderID string, status entity.Status) error {
+	err := orderRepository.UpdateStatusUserOrder(ctx, userOrderID, status)
+	if err != nil {
+		return err
 	}
+	log.Printf("📝 User order %s is now %s", userOrderID, status)
+	return nil
+}
 
-	xDeath, ok := headers["x-death"]
-	if !ok {
-		return 0
-	}
+func storeDLXRecord(ctx context.Context, orderRepository repository.OrderRepository, d amqp.Delivery, payment *entity.Payment, retryCount int, err error) error {
+	paymentID := payment.ID.String()
+	userOrderID := payment.UserOrderID
 
-	// x-death is an array of tables
-	xDeathArray, ok := xDeath.([]any)
-	if !ok || len(xDeathArray) == 0 {
-		return 0
+	// every attempt of the payment, the last one included
+	var history []entity.DLXAttempt
+	attempts, listErr := orderRepository.ListPaymentAttempts(ctx, paymentID)
+	if listErr != nil {
+		log.Printf("⚠️ Failed to list payment attempts, storing DLX record without history: %v", listErr)
 	}
-
-	// Get the first entry (most recent death)
-	firstDeath, ok := xDeathArray[0].(amqp.Table)
-	if !ok {
-		return 0
+	for _, attempt := range attempts {
+		if attempt.Status == entity.PaymentAttemptFailed && attempt.Error != nil {
+			history = append(history, entity.DLXAttempt{Attempt: attempt.Attempt, Error: *attempt.Error, FailedAt: attempt.CreatedAt})
+		}
 	}
 
-	// Extract count field
-	count, ok := firstDeath["count"].(int64)
-	if !ok {
-		return 0
+	dlx := &entity.DLX{
+		ID:              uuid.Must(uuid.NewV7()),
+		PaymentID:       &paymentID,
+		UserOrderID:     &userOrderID,
+		NumberOfRetries: retryCount,
+		IsReplayed:      false,
+		ServiceName:     "payment",
+		Error:           err.Error(),
+		CreatedAt:       time.Now(),
+		Payload:         d.Body,
+		Headers:         d.Headers,
+		RoutingKey:      d.RoutingKey,
+		SourceQueue:     cfg.Queues.Payment,
+		History:         history,
 	}
 
-	return int(count)
+	if err := orderRepository.InsertDLX(ctx, dlx); err != nil {
+		return err
+	}
+	log.Printf("💾 DLX record stored successfully!")
+	log.Printf("   📝 DLX ID: %s", dlx.ID)
+	log.Printf("   💳 Payment ID: %s", paymentID)
+	log.Printf("   🔄 Total Retries: %d", dlx.NumberOfRetries)
+	log.Printf("   ⚠️  Error: %s", dlx.Error)
+	return nil
 }
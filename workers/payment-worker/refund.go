@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"order_processing/entity"
+	"order_processing/gateway"
+	"order_processing/messaging"
+	"order_processing/rabbitmq"
+	"order_processing/repository"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Refunds requested by the saga are rare, one consumer is enough
+func listenRefunds(ctx, workCtx context.Context, conn *rabbitmq.Connection, publisher *rabbitmq.ReconnectingPublisher, paymentGateway gateway.PaymentGateway, orderRepository repository.OrderRepository, retrier *rabbitmq.Retrier) {
+	conn.ConsumeWorkers(ctx, cfg.Queues.PaymentRefund, cfg.PaymentWorker.Prefetch, 1, func(d amqp.Delivery) {
+		processRefund(workCtx, d, publisher, paymentGateway, orderRepository, retrier)
+	})
+}
+
+// Refund a payment of a compensated saga if it was charged. Refunds go
+// through this worker since the simulator and fake gateways only know the
+// charges made here. A refund refused by the gateway is stored in the dlx
+// table for a manual refund.
+func processRefund(ctx context.Context, d amqp.Delivery, publisher *rabbitmq.ReconnectingPublisher, paymentGateway gateway.PaymentGateway, orderRepository repository.OrderRepository, retrier *rabbitmq.Retrier) {
+	var refund entity.PaymentRefundRequestedCommand
+	if _, err := messaging.Decode(d.Body, messaging.TypePaymentRefundRequested, &refund); err != nil {
+		rabbitmq.Park(ctx, publisher, d, cfg.Queues.ParkingLot, cfg.Queues.PaymentRefund, err.Error())
+		return
+	}
+	userOrderID := refund.UserOrderID.String()
+
+	charged, err := isCharged(ctx, paymentGateway, orderRepository, refund.PaymentID.String())
+	if err != nil {
+		retrier.HandleFailure(ctx, d, userOrderID, true, err)
+		return
+	}
+	if !charged {
+		log.Printf("⏭️  Payment %s was never charged, nothing to refund", refund.PaymentID)
+		d.Ack(false)
+		return
+	}
+
+	payment := &entity.Payment{ID: refund.PaymentID, UserOrderID: userOrderID}
+	if err := paymentGateway.Refund(ctx, payment); err != nil {
+		log.Printf("❌ Refund of payment %s failed: %v", payment.ID, err)
+		retrier.HandleFailure(ctx, d, userOrderID, gateway.IsRetryable(err), err)
+		return
+	}
+	log.Printf("💸 Refunded payment %s of user order %s: %s", payment.ID, userOrderID, refund.Reason)
+	d.Ack(false)
+}
+
+// A payment is charged if one of its attempts succeeded or the gateway says
+// so, a charge that timed out may have gone through
+func isCharged(ctx context.Context, paymentGateway gateway.PaymentGateway, orderRepository repository.OrderRepository, paymentID string) (bool, error) {
+	attempts, err := orderRepository.ListPaymentAttempts(ctx, paymentID)
+	if err != nil {
+		return false, err
+	}
+	for _, attempt := range attempts {
+		if attempt.Status == entity.PaymentAttemptSucceeded {
+			return true, nil
+		}
+	}
+
+	status, err := paymentGateway.GetStatus(ctx, paymentID)
+	if err != nil {
+		return false, err
+	}
+	return status == gateway.StatusCharged, nil
+}
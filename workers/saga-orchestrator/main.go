@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"order_processing/client"
+	"order_processing/config"
+	"order_processing/constants"
+	"order_processing/entity"
+	"order_processing/messaging"
+	"order_processing/rabbitmq"
+	"order_processing/repository"
+	"order_processing/topology"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var cfg *config.Config
+
+// sagas compensated per sweep, the rest waits for the next one
+const sweepBatchSize = 100
+
+// orchestrator advances the saga of every user order on the replies of its
+// steps and compensates the sagas that fail or time out
+type orchestrator struct {
+	publisher           *rabbitmq.ReconnectingPublisher
+	orderRepository     repository.OrderRepository
+	inventoryRepository repository.InventoryRepository
+	sagaRepository      repository.SagaRepository
+	retrier             *rabbitmq.Retrier
+}
+
+// Fields of the reply events the saga needs, every event carries the user
+// order, payment events carry the payment as well
+type sagaEvent struct {
+	UserOrderID uuid.UUID  `json:"user_order_id"`
+	PaymentID   *uuid.UUID `json:"payment_id"`
+	Error       string     `json:"error"`
+}
+
+func main() {
+	cfg = config.MustLoad()
+
+	db, err := client.PostgresPool(context.Background(), cfg.Database)
+	rabbitmq.FailOnError(err, "can't connect to database")
+	defer db.Close()
+
+	conn, err := rabbitmq.Dial(context.Background(), cfg.RabbitMQ.URL, topology.New(cfg).Apply)
+	rabbitmq.FailOnError(err, "Failed to connect to RabbitMQ")
+	defer conn.Close()
+	publisher := conn.NewPublisher(5 * time.Second)
+	defer publisher.Close()
+
+	orderRepository := repository.NewOrderRepository(db)
+	o := &orchestrator{
+		publisher:           publisher,
+		orderRepository:     orderRepository,
+		inventoryRepository: repository.NewInventoryRepository(db),
+		sagaRepository:      repository.NewSagaRepository(db),
+		retrier: &rabbitmq.Retrier{
+			Publisher:   publisher,
+			Store:       orderRepository,
+			Exchange:    cfg.Exchanges.DLX,
+			RoutingKey:  constants.RoutingKeySagaRetry,
+			MaxRetries:  cfg.Retry.MaxRetries,
+			Service:     "saga",
+			SourceQueue: cfg.Queues.Saga,
+		},
+	}
+
+	log.Printf(" [*] Waiting for saga events, sagas time out after %s. To exit press CTRL+C", cfg.Saga.Timeout)
+	rabbitmq.Serve(cfg.ShutdownTimeout, func(ctx, workCtx context.Context) {
+		sweeperDone := make(chan struct{})
+		go func() {
+			o.sweepExpiredSagas(ctx, workCtx)
+			close(sweeperDone)
+		}()
+		o.listenEvents(ctx, workCtx, conn)
+		<-sweeperDone
+	})
+}
+
+func (o *orchestrator) listenEvents(ctx, workCtx context.Context, conn *rabbitmq.Connection) {
+	conn.ConsumeWorkers(ctx, cfg.Queues.Saga, cfg.SagaOrchestrator.Prefetch, cfg.SagaOrchestrator.Concurrency, func(d amqp.Delivery) {
+		o.processEvent(workCtx, d)
+	})
+}
+
+func (o *orchestrator) processEvent(ctx context.Context, d amqp.Delivery) {
+	log.Printf(" [x] %s", d.Body)
+	// the queue carries several event types, the AMQP type tells which one
+	// the envelope has to be
+	var event sagaEvent
+	message, err := messaging.Decode(d.Body, d.Type, &event)
+	if err != nil {
+		rabbitmq.Park(ctx, o.publisher, d, cfg.Queues.ParkingLot, cfg.Queues.Saga, err.Error())
+		return
+	}
+
+	if err := o.advance(ctx, message.Type, event); err != nil {
+		// every step of a saga is idempotent, so any failure is retried
+		o.retrier.HandleFailure(ctx, d, event.UserOrderID.String(), true, err)
+		return
+	}
+	d.Ack(false)
+}
+
+// advance applies an event to the saga of its user order, the saga is
+// started by whichever event comes first
+func (o *orchestrator) advance(ctx context.Context, msgType string, event sagaEvent) error {
+	now := time.Now()
+	saga, err := o.sagaRepository.UpsertSaga(ctx, &entity.Saga{
+		UserOrderID: event.UserOrderID,
+		State:       entity.SagaAwaitingStock,
+		Deadline:    now.Add(cfg.Saga.Timeout),
+		CreatedAt:   now,
+	})
+	if err != nil {
+		return err
+	}
+	if event.PaymentID != nil {
+		saga.PaymentID = event.PaymentID
+	}
+
+	switch msgType {
+	case messaging.TypeOrderCreated:
+		log.Printf("saga of user order %s is %s", saga.UserOrderID, saga.State)
+		return nil
+
+	case messaging.TypeStockReserved:
+		saga.StockReserved = true
+		switch saga.State {
+		case entity.SagaAwaitingStock:
+			return o.moveTo(ctx, saga, entity.SagaAwaitingPayment)
+		case entity.SagaCompensating, entity.SagaCancelled:
+			// reserved after the saga gave up, the stock goes back
+			return o.compensate(ctx, saga, "stock reserved after the saga was cancelled")
+		}
+
+	case messaging.TypeStockInsufficient:
+		// inventory-worker cancelled the order already, nothing was done to undo
+		if saga.State == entity.SagaAwaitingStock {
+			saga.Error = "insufficient stock"
+			return o.moveTo(ctx, saga, entity.SagaCancelled)
+		}
+
+	case messaging.TypePaymentSucceeded:
+		switch saga.State {
+		case entity.SagaAwaitingStock, entity.SagaAwaitingPayment:
+			return o.moveTo(ctx, saga, entity.SagaCompleted)
+		case entity.SagaCompensating, entity.SagaCancelled:
+			// charged after the saga gave up, the payment is refunded
+			return o.compensate(ctx, saga, "payment succeeded after the saga was cancelled")
+		}
+
+	case messaging.TypePaymentDeadLettered:
+		if saga.State != entity.SagaCompleted {
+			return o.compensate(ctx, saga, "payment failed: "+event.Error)
+		}
+	}
+
+	log.Printf("saga of user order %s is %s, ignoring %s", saga.UserOrderID, saga.State, msgType)
+	return nil
+}
+
+func (o *orchestrator) moveTo(ctx context.Context, saga *entity.Saga, state entity.SagaState) error {
+	from := saga.State
+	saga.State = state
+	if err := o.sagaRepository.UpdateSaga(ctx, saga); err != nil {
+		return err
+	}
+	log.Printf("saga of user order %s moved from %s to %s", saga.UserOrderID, from, state)
+	return nil
+}
+
+// compensate undoes the finished steps of a saga: the order is cancelled, its
+// stock released and the refund of its payment requested. Every step is
+// idempotent, a saga left compensating by a failed step is compensated again
+// by the sweeper.
+func (o *orchestrator) compensate(ctx context.Context, saga *entity.Saga, reason string) error {
+	if saga.Error == "" {
+		saga.Error = reason
+	}
+	saga.Deadline = time.Now().Add(cfg.Saga.SweepInterval)
+	if err := o.moveTo(ctx, saga, entity.SagaCompensating); err != nil {
+		return err
+	}
+	log.Printf("compensating saga of user order %s: %s", saga.UserOrderID, reason)
+
+	// cancelled first, so payment-worker doesn't start a payment meanwhile
+	userOrderID := saga.UserOrderID.String()
+	err := o.orderRepository.UpdateStatusUserOrder(ctx, userOrderID, entity.StatusCancelled)
+	if errors.Is(err, entity.ErrInvalidTransition) {
+		// the order was paid before its saga timed out, payment.succeeded is on its way
+		log.Printf("user order %s can't be cancelled anymore, completing its saga: %v", userOrderID, err)
+		saga.Error = ""
+		return o.moveTo(ctx, saga, entity.SagaCompleted)
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	if saga.StockReserved {
+		if err := o.inventoryRepository.ReleaseStock(ctx, userOrderID); err != nil {
+			return err
+		}
+		saga.StockReserved = false
+		log.Printf("released stock of user order %s", userOrderID)
+	}
+
+	if err := o.refundPayment(ctx, saga, saga.Error); err != nil {
+		return err
+	}
+
+	return o.moveTo(ctx, saga, entity.SagaCancelled)
+}
+
+// refundPayment asks payment-worker to refund the payment of the saga, it
+// refunds it if it was charged. Only payment-worker knows the charges of the
+// simulator and fake gateways.
+func (o *orchestrator) refundPayment(ctx context.Context, saga *entity.Saga, reason string) error {
+	if saga.PaymentID == nil {
+		userOrder, err := o.orderRepository.GetUserOrderStatus(ctx, saga.UserOrderID.String())
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if userOrder.PaymentID == nil {
+			return nil
+		}
+		paymentID, err := uuid.Parse(*userOrder.PaymentID)
+		if err != nil {
+			return err
+		}
+		saga.PaymentID = &paymentID
+	}
+
+	message, err := messaging.New(messaging.TypePaymentRefundRequested, saga.UserOrderID.String(), entity.PaymentRefundRequestedCommand{
+		UserOrderID: saga.UserOrderID,
+		PaymentID:   *saga.PaymentID,
+		Reason:      reason,
+		RequestedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	publishing, err := message.Publishing()
+	if err != nil {
+		return err
+	}
+	if err := o.publisher.Publish(ctx, cfg.Exchanges.PaymentDirect, constants.RoutingKeyPaymentRefund, publishing); err != nil {
+		return err
+	}
+	log.Printf("requested refund of payment %s of user order %s", saga.PaymentID, saga.UserOrderID)
+	return nil
+}
+
+// sweepExpiredSagas compensates sagas that missed their deadline, and sagas
+// whose compensation failed halfway, every sweep interval
+func (o *orchestrator) sweepExpiredSagas(ctx, workCtx context.Context) {
+	ticker := time.NewTicker(cfg.Saga.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sagas, err := o.sagaRepository.ListExpiredSagas(workCtx, time.Now(), sweepBatchSize)
+		if err != nil {
+			log.Printf("unable to list expired sagas: %v", err)
+			continue
+		}
+		for i := range sagas {
+			saga := &sagas[i]
+			reason := fmt.Sprintf("timed out %s", saga.State)
+			err := o.compensate(workCtx, saga, reason)
+			if errors.Is(err, repository.ErrSagaChanged) {
+				// advanced by an event meanwhile, looked at again next sweep if still expired
+				continue
+			}
+			if err != nil {
+				log.Printf("unable to compensate saga of user order %s, retrying next sweep: %v", saga.UserOrderID, err)
+			}
+		}
+	}
+}
@@ -2,80 +2,125 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"time"
 
 	"order_processing/client"
+	"order_processing/config"
 	"order_processing/constants"
 	"order_processing/entity"
+	"order_processing/messaging"
 	"order_processing/repository"
+	"order_processing/topology"
 
 	"order_processing/rabbitmq"
 
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+var cfg *config.Config
+
 func main() {
-	conn := rabbitmq.RabbitMQSetup()
+	cfg = config.MustLoad()
+
+	db, err := client.PostgresPool(context.Background(), cfg.Database)
+	rabbitmq.FailOnError(err, "can't connect to database")
+	defer db.Close()
+	orderRepository := repository.NewOrderRepository(db)
+
+	conn, err := rabbitmq.Dial(context.Background(), cfg.RabbitMQ.URL, topology.New(cfg).Apply)
+	rabbitmq.FailOnError(err, "Failed to connect to RabbitMQ")
 	defer conn.Close()
-	ch := rabbitmq.GetChannel(conn)
-	defer ch.Close()
+	publisher := conn.NewPublisher(5 * time.Second)
+	defer publisher.Close()
 
-	// make exchange user order
-	err := ch.ExchangeDeclare(constants.ExchangeUserOrderDirect, "direct", true, false, false, false, nil)
-	rabbitmq.FailOnError(err, "can't create exchange user order")
+	retrier := &rabbitmq.Retrier{
+		Publisher:   publisher,
+		Store:       orderRepository,
+		Exchange:    cfg.Exchanges.DLX,
+		RoutingKey:  constants.RoutingKeyUserOrderRetry,
+		MaxRetries:  cfg.Retry.MaxRetries,
+		Service:     "user_order",
+		SourceQueue: cfg.Queues.UserOrder,
+	}
 
-	// make userOrder queue
-	userQueue, err := ch.QueueDeclare(constants.UserOrderQueue, true, false, false, false, nil)
-	rabbitmq.FailOnError(err, "can't create user queue")
+	log.Printf(" [*] Waiting for logs. To exit press CTRL+C")
+	rabbitmq.Serve(cfg.ShutdownTimeout, func(ctx, workCtx context.Context) {
+		listenUserOrder(ctx, workCtx, conn, publisher, orderRepository, retrier)
+	})
+}
 
-	// bind user queue to user order exchange
-	err = ch.QueueBind(userQueue.Name, constants.RoutingKeyUserOrder, constants.ExchangeUserOrderDirect, false, nil)
-	rabbitmq.FailOnError(err, "can't bind user queue to user order exchange")
-	listenUserOrder(ch, userQueue)
+func listenUserOrder(ctx, workCtx context.Context, conn *rabbitmq.Connection, publisher *rabbitmq.ReconnectingPublisher, orderRepository repository.OrderRepository, retrier *rabbitmq.Retrier) {
+	conn.ConsumeWorkers(ctx, cfg.Queues.UserOrder, cfg.UserOrderWorker.Prefetch, cfg.UserOrderWorker.Concurrency, func(d amqp.Delivery) {
+		processUserOrder(workCtx, d, publisher, orderRepository, retrier)
+	})
 }
 
-func listenUserOrder(ch *amqp.Channel, userQueue amqp.Queue) {
-	msgs, err := ch.Consume(
-		userQueue.Name, // queue
-		"",             // consumer
-		true,           // auto ack
-		false,          // exclusive
-		false,          // no local
-		false,          // no wait
-		nil,            // args
-	)
-	rabbitmq.FailOnError(err, "Failed to register a consumer")
-	var forever chan struct{}
-
-	go func() {
-		for d := range msgs {
-			log.Printf(" [x] %s", d.Body)
-			var userOrderRequest entity.UserOrderRequest
-			err := json.Unmarshal(d.Body, &userOrderRequest)
-			rabbitmq.FailOnError(err, "unable to unmarshal user order request")
-
-			var userOrder entity.UserOrder
-			log.Println("user order id in create service", userOrderRequest.ID)
-			userOrder.ID = userOrderRequest.ID
-			userOrder.UserID = userOrderRequest.UserID
-			userOrder.ProductID = userOrderRequest.ProductID
-			userOrder.CreatedAt = time.Now()
-			userOrder.Location = userOrderRequest.Location
-			userOrder.Status = entity.StatusPending
-			userOrder.Quantity = userOrderRequest.Quantity
-
-			db := client.PostgresClient(constants.Username, constants.Password, constants.Host, constants.Port, constants.DBName)
-			orderRepository := repository.NewOrderRepository(db)
-			err = orderRepository.InsertUserOrder(context.Background(), &userOrder)
-			if err != nil {
-				panic(err)
-			}
-			log.Println("user order created successful")
-		}
-	}()
+// Every delivery is acked, parked, retried or requeued, none is dropped
+func processUserOrder(ctx context.Context, d amqp.Delivery, publisher *rabbitmq.ReconnectingPublisher, orderRepository repository.OrderRepository, retrier *rabbitmq.Retrier) {
+	log.Printf(" [x] %s", d.Body)
+	// malformed messages and unknown versions can never succeed, they are parked for inspection
+	var userOrderRequest entity.UserOrderRequest
+	message, err := messaging.Decode(d.Body, messaging.TypeUserOrderRequested, &userOrderRequest)
+	if err != nil {
+		rabbitmq.Park(ctx, publisher, d, cfg.Queues.ParkingLot, cfg.Queues.UserOrder, err.Error())
+		return
+	}
+	if userOrderRequest.ID == uuid.Nil {
+		rabbitmq.Park(ctx, publisher, d, cfg.Queues.ParkingLot, cfg.Queues.UserOrder, "user order request has no id")
+		return
+	}
 
-	log.Printf(" [*] Waiting for logs. To exit press CTRL+C")
-	<-forever
+	var userOrder entity.UserOrder
+	log.Println("user order id in create service", userOrderRequest.ID)
+	userOrder.ID = userOrderRequest.ID
+	userOrder.UserID = userOrderRequest.UserID
+	userOrder.ProductID = userOrderRequest.ProductID
+	userOrder.CreatedAt = time.Now()
+	userOrder.Location = userOrderRequest.Location
+	userOrder.Status = entity.StatusPending
+	userOrder.Quantity = userOrderRequest.Quantity
+
+	if err := orderRepository.InsertUserOrder(ctx, &userOrder); err != nil {
+		retrier.HandleFailure(ctx, d, userOrder.ID.String(), repository.IsTransient(err), err)
+		return
+	}
+	log.Println("user order created successful")
+
+	// stock is reserved and paid only after the user order row exists
+	if err := publishOrderCreated(ctx, publisher, message.CorrelationID, &userOrder); err != nil {
+		// the insert is idempotent, so every failed publish is retried
+		retrier.HandleFailure(ctx, d, userOrder.ID.String(), rabbitmq.IsPublishError(err), err)
+		return
+	}
+
+	d.Ack(false)
+}
+
+func publishOrderCreated(ctx context.Context, publisher *rabbitmq.ReconnectingPublisher, correlationID string, userOrder *entity.UserOrder) error {
+	message, err := messaging.New(messaging.TypeOrderCreated, correlationID, entity.OrderCreatedEvent{
+		UserOrderID: userOrder.ID,
+		UserID:      userOrder.UserID,
+		ProductID:   userOrder.ProductID,
+		Quantity:    userOrder.Quantity,
+		Location:    userOrder.Location,
+		CreatedAt:   userOrder.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	publishing, err := message.Publishing()
+	if err != nil {
+		return err
+	}
+
+	// the stock broadcast fans it out to every service reacting to new orders
+	err = publisher.Publish(ctx, cfg.Exchanges.StockBroadcast, constants.RoutingKeyOrderCreated, publishing)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Order Created: [x] Sent %s", publishing.Body)
+	return nil
 }